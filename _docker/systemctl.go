@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"golang.org/x/sys/unix"
 	"os"
 	"reflect"
 	"regexp"
@@ -32,30 +33,41 @@ func systemctl() int {
 
 			return 0
 		}
-	case 11:
-		if reflect.DeepEqual(os.Args[:10], []string{
+	case 23:
+		if reflect.DeepEqual(os.Args[:22], []string{
 			"/bin/systemctl", "show",
 			"-p", "ActiveState",
 			"-p", "SubState",
-			"-p", "ExecMainStartTimestamp",
+			"-p", "ExecMainStartTimestampMonotonic",
 			"-p", "FragmentPath",
+			"-p", "ControlGroup",
+			"-p", "ExecMainPID",
+			"-p", "ExecStart",
+			"-p", "ExecStartPre",
+			"-p", "ExecStartPost",
+			"-p", "ExecReload",
 		}) {
-			if match := serviceUnit.FindStringSubmatch(os.Args[10]); match != nil {
+			if match := serviceUnit.FindStringSubmatch(os.Args[22]); match != nil {
 				service := match[1]
 
 				if fragmentPath, hasFP := services[service]; hasFP {
-					var activeSince time.Time
+					var activeSince uint64 = 1
 
 					if time.Now().Unix()%120 < 60 {
-						activeSince = time.Now()
-					} else {
-						activeSince = time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)
+						var now unix.Timespec
+						if unix.ClockGettime(unix.CLOCK_MONOTONIC, &now) != nil {
+							return 1
+						}
+
+						activeSince = uint64(now.Nano() / int64(time.Microsecond))
 					}
 
 					fmt.Printf(
-						"ActiveState=active\nSubState=running\nExecMainStartTimestamp=%s\nFragmentPath=%s\n",
-						activeSince.Format("Mon 2006-01-02 15:04:05 MST"),
+						"ActiveState=active\nSubState=running\nExecMainStartTimestampMonotonic=%d\nFragmentPath=%s\n"+
+							"ControlGroup=/system.slice/%s.service\nExecMainPID=0\n",
+						activeSince,
 						fragmentPath,
+						service,
 					)
 					return 0
 				}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const apkInstalledDb = "/lib/apk/db/installed"
+
+var apkRecord = regexp.MustCompile(`\A([A-Za-z]):(.*)\z`)
+var apkPackageName = regexp.MustCompile(`\A([^<>=~]+)`)
+
+// apkProtectedDir matches the directories apk treats like dpkg Conffiles.
+var apkProtectedDir = regexp.MustCompile(`\Aetc(?:/|\z)`)
+
+type apkBackend struct{}
+
+func init() {
+	registerPackageBackend("apk", 30, apkBackend{})
+}
+
+func (apkBackend) Detect() bool {
+	_, errStat := os.Stat(apkInstalledDb)
+	return errStat == nil
+}
+
+func (apkBackend) DatabaseFiles() []string {
+	return []string{apkInstalledDb}
+}
+
+func (apkBackend) ShowPackages() (packagesInfo, map[string]error) {
+	return apkShowPackages()
+}
+
+func apkShowPackages() (packagesInfo, map[string]error) {
+	rawPackages, errRF := ioutil.ReadFile(apkInstalledDb)
+	if errRF != nil {
+		return packagesInfo{}, map[string]error{"cat " + apkInstalledDb: errRF}
+	}
+
+	return apkParseInstalled(rawPackages), nil
+}
+
+// apkParseInstalled parses apk's installed database.
+func apkParseInstalled(rawPackages []byte) packagesInfo {
+	packageMetaData := map[string]packageInfo{}
+	nonConfFiles := map[string]string{}
+	allFiles := map[string]string{}
+	packag := ""
+	dir := ""
+	var pkgInfo packageInfo
+
+	for _, line := range bytes.Split(rawPackages, lineBreak) {
+		if len(line) < 1 {
+			packag = ""
+			continue
+		}
+
+		match := apkRecord.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		value := string(match[2])
+
+		if match[1][0] == 'P' {
+			packag = value
+			dir = ""
+			pkgInfo = packageInfo{
+				deps:         map[string]struct{}{},
+				aliases:      map[string]struct{}{},
+				nonConfFiles: map[string]struct{}{},
+			}
+
+			packageMetaData[packag] = pkgInfo
+			continue
+		}
+
+		if packag == "" {
+			continue
+		}
+
+		switch match[1][0] {
+		case 'D':
+			for _, dep := range strings.Fields(value) {
+				// Conflicts are not dependencies.
+				if dep[0] != '!' {
+					if name := apkPackageName.FindString(dep); name != "" {
+						pkgInfo.deps[name] = struct{}{}
+					}
+				}
+			}
+		case 'p', 'r':
+			for _, alias := range strings.Fields(value) {
+				if name := apkPackageName.FindString(alias); name != "" {
+					pkgInfo.aliases[name] = struct{}{}
+				}
+			}
+		case 'F':
+			dir = value
+		case 'R':
+			file := "/" + value
+			if dir != "" {
+				file = "/" + dir + file
+			}
+
+			allFiles[file] = packag
+
+			if !apkProtectedDir.MatchString(dir) {
+				pkgInfo.nonConfFiles[file] = struct{}{}
+				nonConfFiles[file] = packag
+			}
+		}
+	}
+
+	// Like RPM, apk allows to depend on files (e.g. /bin/sh).
+	for _, pkgInfo := range packageMetaData {
+		for dep := range pkgInfo.deps {
+			if dep[0] == '/' {
+				if owner, hasOwner := allFiles[dep]; hasOwner {
+					delete(pkgInfo.deps, dep)
+					pkgInfo.deps[owner] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return packagesInfo{packages: packageMetaData, nonConfFiles: nonConfFiles}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApkParseInstalled(t *testing.T) {
+	packages := apkParseInstalled([]byte(`C:Q1abc=
+P:nginx
+V:1.24.0-r7
+D:so:libc.musl-x86_64.so.1 pcre2>=10.42 !nginx-mod-legacy /bin/sh
+p:cmd:nginx=1.24.0-r7
+F:etc/nginx
+R:nginx.conf
+F:usr/sbin
+R:nginx
+
+P:busybox
+V:1.36.1-r15
+r:busybox-initscripts
+F:bin
+R:sh
+
+P:musl
+V:1.2.4-r2
+p:so:libc.musl-x86_64.so.1=1
+F:lib
+R:ld-musl-x86_64.so.1
+`))
+
+	nginx := packages.packages["nginx"]
+
+	// Conflicts are left out, versions are stripped and file dependencies are replaced by their owners.
+	if expected := set("so:libc.musl-x86_64.so.1", "pcre2", "busybox"); !reflect.DeepEqual(nginx.deps, expected) {
+		t.Errorf("deps of nginx: expected %v, got %v", expected, nginx.deps)
+	}
+
+	if expected := set("cmd:nginx"); !reflect.DeepEqual(nginx.aliases, expected) {
+		t.Errorf("aliases of nginx: expected %v, got %v", expected, nginx.aliases)
+	}
+
+	// Files in /etc are protected like config files.
+	if expected := set("/usr/sbin/nginx"); !reflect.DeepEqual(nginx.nonConfFiles, expected) {
+		t.Errorf("files of nginx: expected %v, got %v", expected, nginx.nonConfFiles)
+	}
+
+	if expected := set("busybox-initscripts"); !reflect.DeepEqual(packages.packages["busybox"].aliases, expected) {
+		t.Errorf("aliases of busybox: expected %v, got %v", expected, packages.packages["busybox"].aliases)
+	}
+
+	if owner := packages.nonConfFiles["/lib/ld-musl-x86_64.so.1"]; owner != "musl" {
+		t.Errorf("owner of /lib/ld-musl-x86_64.so.1: expected musl, got %q", owner)
+	}
+
+	if len(packages.packages) != 3 {
+		t.Errorf("expected 3 packages, got %d", len(packages.packages))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type severity uint8
+
+const (
+	severityOK severity = iota
+	severityWarning
+	severityCritical
+)
+
+var severities = map[string]severity{"ok": severityOK, "warning": severityWarning, "critical": severityCritical}
+
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return
+}
+
+// configFile is the TOML schema of a config file (or snippet).
+type configFile struct {
+	Services map[string]servicePolicyConfig `toml:"services"`
+}
+
+// servicePolicyConfig is a [services."pattern"] table. Unset fields don't override other matching tables.
+type servicePolicyConfig struct {
+	Severity       *string   `toml:"severity"`
+	GracePeriod    *duration `toml:"grace_period"`
+	CriticalAfter  *duration `toml:"critical_after"`
+	IgnorePackages []string  `toml:"ignore_packages"`
+	RebootInstead  *bool     `toml:"reboot_instead"`
+}
+
+type servicePolicyRule struct {
+	service        pattern
+	severity       *severity
+	gracePeriod    *time.Duration
+	criticalAfter  *time.Duration
+	ignorePackages patternList
+	rebootInstead  *bool
+}
+
+// servicePolicy is the effective configuration of a service.
+type servicePolicy struct {
+	severity       severity
+	gracePeriod    time.Duration
+	criticalAfter  time.Duration
+	ignorePackages patternList
+	rebootInstead  bool
+}
+
+const defaultConfigFile = "/etc/check_systemd_needrestart.toml"
+
+// servicePolicyRules are applied in order, so later matching rules override earlier ones.
+var servicePolicyRules []servicePolicyRule
+
+// loadConfig (re-)loads the config file (if any) and the *.toml snippets in the .d directory next to it.
+func loadConfig() (errs map[string]error) {
+	file := configPath
+	if file == "" {
+		file = defaultConfigFile
+	}
+
+	files := []string{file}
+	snippetsDir := strings.TrimSuffix(file, ".toml") + ".d"
+
+	snippets, errGlob := filepath.Glob(filepath.Join(snippetsDir, "*.toml"))
+	if errGlob != nil {
+		return map[string]error{"ls " + snippetsDir: errGlob}
+	}
+
+	sort.Strings(snippets)
+
+	var allRules []servicePolicyRule
+	errs = map[string]error{}
+
+	for _, file := range append(files, snippets...) {
+		rules, errLCF := loadConfigFile(file)
+		if errLCF != nil {
+			// Only an explicitly given config file is mandatory.
+			if !(os.IsNotExist(errLCF) && file == defaultConfigFile && configPath == "") {
+				errs[file] = errLCF
+			}
+
+			continue
+		}
+
+		allRules = append(allRules, rules...)
+	}
+
+	if len(errs) > 0 {
+		return
+	}
+
+	servicePolicyRules = allRules
+	return nil
+}
+
+func loadConfigFile(file string) ([]servicePolicyRule, error) {
+	var config configFile
+
+	meta, errDF := toml.DecodeFile(file, &config)
+	if errDF != nil {
+		return nil, errDF
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown option %q", undecoded[0].String())
+	}
+
+	// Tables are applied in file order, so e.g. a catch-all table doesn't override more specific ones after it.
+	services := make([]string, 0, len(config.Services))
+	for _, key := range meta.Keys() {
+		if len(key) == 2 && key[0] == "services" {
+			services = append(services, key[1])
+		}
+	}
+
+	rules := make([]servicePolicyRule, 0, len(services))
+
+	for _, service := range services {
+		policy := config.Services[service]
+		var rule servicePolicyRule
+
+		var patterns patternList
+		if errSet := patterns.Set(service); errSet != nil {
+			return nil, fmt.Errorf("services.%q: %s", service, errSet.Error())
+		}
+
+		rule.service = patterns[0]
+
+		if policy.Severity != nil {
+			sev, isSeverity := severities[*policy.Severity]
+			if !isSeverity {
+				return nil, fmt.Errorf(
+					"services.%q.severity: invalid severity %q (expected ok, warning or critical)", service, *policy.Severity,
+				)
+			}
+
+			rule.severity = &sev
+		}
+
+		if policy.GracePeriod != nil {
+			if policy.GracePeriod.Duration < 0 {
+				return nil, fmt.Errorf("services.%q.grace_period: must not be negative", service)
+			}
+
+			rule.gracePeriod = &policy.GracePeriod.Duration
+		}
+
+		if policy.CriticalAfter != nil {
+			if policy.CriticalAfter.Duration < 0 {
+				return nil, fmt.Errorf("services.%q.critical_after: must not be negative", service)
+			}
+
+			rule.criticalAfter = &policy.CriticalAfter.Duration
+		}
+
+		for _, packag := range policy.IgnorePackages {
+			if errSet := rule.ignorePackages.Set(packag); errSet != nil {
+				return nil, fmt.Errorf("services.%q.ignore_packages: %s", service, errSet.Error())
+			}
+		}
+
+		rule.rebootInstead = policy.RebootInstead
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// policyFor merges the policies of all rules matching the service.
+func policyFor(service string) servicePolicy {
+	policy := servicePolicy{severity: severityCritical, gracePeriod: gracePeriod, criticalAfter: criticalAfter}
+
+	for _, rule := range servicePolicyRules {
+		if rule.service.match(service) {
+			if rule.severity != nil {
+				policy.severity = *rule.severity
+			}
+
+			if rule.gracePeriod != nil {
+				policy.gracePeriod = *rule.gracePeriod
+			}
+
+			if rule.criticalAfter != nil {
+				policy.criticalAfter = *rule.criticalAfter
+			}
+
+			if rule.rebootInstead != nil {
+				policy.rebootInstead = *rule.rebootInstead
+			}
+
+			policy.ignorePackages = append(policy.ignorePackages, rule.ignorePackages...)
+		}
+	}
+
+	return policy
+}
+
+func (s severity) String() string {
+	for name, sev := range severities {
+		if sev == s {
+			return name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadConfigFileOrder(t *testing.T) {
+	dir, errTD := ioutil.TempDir("", "config")
+	if errTD != nil {
+		t.Fatal(errTD)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "check_systemd_needrestart.toml")
+	writeFile(t, file, `[services."~.*"]
+severity = "warning"
+
+[services."cron"]
+severity = "ok"
+
+[services."sshd"]
+severity = "critical"
+`)
+
+	rules, errLCF := loadConfigFile(file)
+	if errLCF != nil {
+		t.Fatal(errLCF)
+	}
+
+	defer func(rules []servicePolicyRule) { servicePolicyRules = rules }(servicePolicyRules)
+	servicePolicyRules = rules
+
+	// The specific tables override the catch-all one before them.
+	for service, expected := range map[string]severity{"cron": severityOK, "sshd": severityCritical, "nginx": severityWarning} {
+		if actual := policyFor(service).severity; actual != expected {
+			t.Errorf("%s: expected severity %s, got %s", service, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"strings"
+	"time"
+)
+
+const dbusSystemd = "org.freedesktop.systemd1"
+
+type dbusUnit struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Followed    string
+	Path        dbus.ObjectPath
+	JobId       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+// systemBus connects to the system bus unless it's unavailable (nil).
+func systemBus() *dbus.Conn {
+	conn, errSB := dbus.SystemBus()
+	if errSB != nil {
+		return nil
+	}
+
+	return conn
+}
+
+// dbusListServices does the same as listServices(""), but via D-Bus.
+func dbusListServices(conn *dbus.Conn) (map[string]dbus.ObjectPath, error) {
+	var units []dbusUnit
+
+	errLU := dbusCall(
+		"dbus "+dbusSystemd+".Manager.ListUnits", conn.Object(dbusSystemd, "/org/freedesktop/systemd1"),
+		dbusSystemd+".Manager.ListUnits", &units,
+	)
+	if errLU != nil {
+		return nil, errLU
+	}
+
+	services := map[string]dbus.ObjectPath{}
+
+	for _, unit := range units {
+		// Like systemctl list-units
+		if unit.ActiveState != "inactive" || unit.JobId != 0 {
+			if match := serviceUnit.FindStringSubmatch(unit.Name); match != nil {
+				services[match[1]] = unit.Path
+			}
+		}
+	}
+
+	return services, nil
+}
+
+// dbusShowService does the same as showService("", ...), but via D-Bus.
+func dbusShowService(conn *dbus.Conn, service string, path dbus.ObjectPath, ch chan<- systemctlShowResult) {
+	unit := conn.Object(dbusSystemd, path)
+	properties := map[string]dbus.Variant{}
+
+	for _, iface := range [2]string{dbusSystemd + ".Unit", dbusSystemd + ".Service"} {
+		var ifaceProperties map[string]dbus.Variant
+
+		context := "dbus " + string(path) + " " + iface
+
+		if errGA := dbusCall(context, unit, "org.freedesktop.DBus.Properties.GetAll", &ifaceProperties, iface); errGA != nil {
+			ch <- systemctlShowResult{cmd: context, err: errGA}
+			return
+		}
+
+		for property, value := range ifaceProperties {
+			properties[property] = value
+		}
+	}
+
+	var activeSince time.Time
+	var mainPid uint64 = 0
+
+	if dbusString(properties["ActiveState"]) == "active" && dbusString(properties["SubState"]) == "running" {
+		usec, ok := properties["ExecMainStartTimestampMonotonic"].Value().(uint64)
+		if !ok || usec == 0 {
+			ch <- systemctlShowResult{
+				cmd: "dbus " + string(path),
+				err: fmt.Errorf(
+					"invalid ExecMainStartTimestampMonotonic: %s", properties["ExecMainStartTimestampMonotonic"].String(),
+				),
+			}
+			return
+		}
+
+		var errMTT error
+		if activeSince, errMTT = monotonicToTime(usec); errMTT != nil {
+			ch <- systemctlShowResult{cmd: "clock_gettime CLOCK_MONOTONIC", err: errMTT}
+			return
+		}
+
+		if pid, ok := properties["ExecMainPID"].Value().(uint32); ok {
+			mainPid = uint64(pid)
+		}
+	}
+
+	var execPaths []string
+
+	for property := range execProperties {
+		// a(sasbttttuii), the path comes first
+		if commands, ok := properties[property].Value().([][]interface{}); ok {
+			for _, command := range commands {
+				if len(command) > 0 {
+					if path, ok := command[0].(string); ok && strings.HasPrefix(path, "/") {
+						execPaths = append(execPaths, path)
+					}
+				}
+			}
+		}
+	}
+
+	inspectServiceProcesses(
+		systemctlShowResult{
+			service:      service,
+			cmd:          "dbus " + string(path),
+			activeSince:  activeSince,
+			fragmentPath: dbusString(properties["FragmentPath"]),
+			execPaths:    execPaths,
+			controlGroup: dbusString(properties["ControlGroup"]),
+			mainPid:      mainPid,
+			err:          nil,
+		},
+		ch,
+	)
+}
+
+// dbusShowServiceDeps does the same as showServiceDeps("", service), but via D-Bus.
+func dbusShowServiceDeps(conn *dbus.Conn, service string) (string, serviceDeps, error) {
+	context := "dbus " + dbusSystemd + ".Manager.GetUnit " + service + ".service"
+	manager := conn.Object(dbusSystemd, "/org/freedesktop/systemd1")
+
+	var path dbus.ObjectPath
+	if errGU := dbusCall(context, manager, dbusSystemd+".Manager.GetUnit", &path, service+".service"); errGU != nil {
+		return context, serviceDeps{}, errGU
+	}
+
+	context = "dbus " + string(path) + " " + dbusSystemd + ".Unit"
+	var rawProperties map[string]dbus.Variant
+
+	errGA := dbusCall(context, conn.Object(dbusSystemd, path), "org.freedesktop.DBus.Properties.GetAll", &rawProperties, dbusSystemd+".Unit")
+	if errGA != nil {
+		return context, serviceDeps{}, errGA
+	}
+
+	properties := map[string][]string{}
+
+	for _, property := range [7]string{"Requires", "BindsTo", "PartOf", "RequiredBy", "BoundBy", "ConsistsOf", "After"} {
+		properties[property], _ = rawProperties[property].Value().([]string)
+	}
+
+	return context, newServiceDeps("", properties), nil
+}
+
+// dbusCall is like Call, but a worker operation which is aborted once the check has been cancelled.
+func dbusCall(operation string, object dbus.BusObject, method string, result interface{}, args ...interface{}) error {
+	ctx, end, errBO := beginOperation(operation)
+	defer end()
+
+	if errBO != nil {
+		return errBO
+	}
+
+	return object.CallWithContext(ctx, method, 0, args...).Store(result)
+}
+
+func dbusString(value dbus.Variant) string {
+	s, _ := value.Value().(string)
+	return s
+}
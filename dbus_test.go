@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecCommand is an element of ExecStart= etc. (sasbttttuii).
+type fakeExecCommand struct {
+	Path                    string
+	Argv                    []string
+	IgnoreErrors            bool
+	StartTimestamp          uint64
+	StartTimestampMonotonic uint64
+	ExitTimestamp           uint64
+	ExitTimestampMonotonic  uint64
+	Pid                     uint32
+	Code                    int32
+	Status                  int32
+}
+
+type fakeUnit struct {
+	activeState string
+	subState    string
+	// properties are by interface.
+	properties map[string]map[string]dbus.Variant
+	// restartResult is the result of restart jobs.
+	restartResult string
+}
+
+// fakeSystemd stands in for systemd on a private bus.
+type fakeSystemd struct {
+	// mu isn't embedded, not to export Lock and Unlock via D-Bus.
+	mu        sync.Mutex
+	conn      *dbus.Conn
+	units     map[string]fakeUnit
+	restarted []string
+	jobs      uint32
+}
+
+type fakeUnitProperties struct {
+	unit fakeUnit
+}
+
+func init() {
+	// Like main()
+	workers = make(chan struct{}, 4)
+}
+
+func fakeUnitPath(name string) dbus.ObjectPath {
+	return dbus.ObjectPath(
+		"/org/freedesktop/systemd1/unit/" + strings.NewReplacer(".", "_2e", "-", "_2d", "@", "_40").Replace(name),
+	)
+}
+
+func (f *fakeSystemd) ListUnits() ([]dbusUnit, *dbus.Error) {
+	var units []dbusUnit
+
+	for name, unit := range f.units {
+		units = append(units, dbusUnit{
+			Name:        name,
+			LoadState:   "loaded",
+			ActiveState: unit.activeState,
+			SubState:    unit.subState,
+			Path:        fakeUnitPath(name),
+			JobPath:     "/",
+		})
+	}
+
+	return units, nil
+}
+
+func (f *fakeSystemd) GetUnit(name string) (dbus.ObjectPath, *dbus.Error) {
+	if _, hasUnit := f.units[name]; !hasUnit {
+		return "", dbus.NewError(dbusSystemd+".NoSuchUnit", []interface{}{"Unit " + name + " not loaded."})
+	}
+
+	return fakeUnitPath(name), nil
+}
+
+func (f *fakeSystemd) Subscribe() *dbus.Error {
+	return nil
+}
+
+func (f *fakeSystemd) RestartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	f.jobs++
+	f.restarted = append(f.restarted, name)
+	id := f.jobs
+	f.mu.Unlock()
+
+	job := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/systemd1/job/%d", id))
+	result := f.units[name].restartResult
+
+	if result == "" {
+		result = "done"
+	}
+
+	// Like systemd, once the job has been enqueued.
+	go f.conn.Emit("/org/freedesktop/systemd1", dbusSystemd+".Manager.JobRemoved", id, job, name, result)
+
+	return job, nil
+}
+
+func (p fakeUnitProperties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	properties := map[string]dbus.Variant{}
+
+	if iface == dbusSystemd+".Unit" {
+		properties["ActiveState"] = dbus.MakeVariant(p.unit.activeState)
+		properties["SubState"] = dbus.MakeVariant(p.unit.subState)
+	}
+
+	for property, value := range p.unit.properties[iface] {
+		properties[property] = value
+	}
+
+	return properties, nil
+}
+
+// startFakeSystemd runs a private bus with fakeSystemd serving units and returns a client connection.
+func startFakeSystemd(t *testing.T, units map[string]fakeUnit) (*dbus.Conn, *fakeSystemd, func()) {
+	t.Helper()
+
+	if _, errLP := exec.LookPath("dbus-daemon"); errLP != nil {
+		t.Skip(errLP)
+	}
+
+	daemon := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+
+	stdout, errSP := daemon.StdoutPipe()
+	if errSP != nil {
+		t.Fatal(errSP)
+	}
+
+	if errSt := daemon.Start(); errSt != nil {
+		t.Fatal(errSt)
+	}
+
+	var conns []*dbus.Conn
+
+	stop := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		daemon.Process.Kill()
+		daemon.Wait()
+	}
+
+	connect := func(address string) *dbus.Conn {
+		conn, errCn := dbus.Connect(address)
+		if errCn != nil {
+			stop()
+			t.Fatal(errCn)
+		}
+
+		conns = append(conns, conn)
+		return conn
+	}
+
+	address, errRS := bufio.NewReader(stdout).ReadString('\n')
+	if errRS != nil {
+		stop()
+		t.Fatal(errRS)
+	}
+
+	address = strings.TrimSpace(address)
+	systemd := &fakeSystemd{conn: connect(address), units: units}
+
+	if _, errRN := systemd.conn.RequestName(dbusSystemd, dbus.NameFlagDoNotQueue); errRN != nil {
+		stop()
+		t.Fatal(errRN)
+	}
+
+	systemd.conn.Export(systemd, "/org/freedesktop/systemd1", dbusSystemd+".Manager")
+
+	for name, unit := range units {
+		systemd.conn.Export(fakeUnitProperties{unit}, fakeUnitPath(name), "org.freedesktop.DBus.Properties")
+	}
+
+	return connect(address), systemd, stop
+}
+
+func TestShowServicesDBus(t *testing.T) {
+	var now unix.Timespec
+	if errCG := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); errCG != nil {
+		t.Fatal(errCG)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	startedMonotonic := uint64((time.Duration(now.Nano()) - time.Hour) / time.Microsecond)
+
+	conn, _, stop := startFakeSystemd(t, map[string]fakeUnit{
+		"nginx.service": {
+			activeState: "active",
+			subState:    "running",
+			properties: map[string]map[string]dbus.Variant{
+				dbusSystemd + ".Unit": {
+					"FragmentPath": dbus.MakeVariant("/lib/systemd/system/nginx.service"),
+				},
+				dbusSystemd + ".Service": {
+					// The wall clock one may be off due to clock changes since then.
+					"ExecMainStartTimestamp":          dbus.MakeVariant(uint64(0)),
+					"ExecMainStartTimestampMonotonic": dbus.MakeVariant(startedMonotonic),
+					"ExecMainPID":                     dbus.MakeVariant(uint32(os.Getpid())),
+					"ControlGroup":                    dbus.MakeVariant("/system.slice/nginx.service"),
+					"ExecStartPre":                    dbus.MakeVariant([]fakeExecCommand{{Path: "/usr/sbin/nginx", Argv: []string{"nginx", "-t"}}}),
+					"ExecStart":                       dbus.MakeVariant([]fakeExecCommand{{Path: "/usr/sbin/nginx", Argv: []string{"nginx"}}}),
+					"ExecReload":                      dbus.MakeVariant([]fakeExecCommand{}),
+				},
+			},
+		},
+		"stopping.service": {activeState: "deactivating", subState: "stop-sigterm"},
+		"dead.service":     {activeState: "inactive", subState: "dead"},
+		"dbus.socket":      {activeState: "active", subState: "running"},
+	})
+	defer stop()
+
+	ch := make(chan servicesInfo, 1)
+	showServices(conn, ch)
+	services := <-ch
+
+	if services.services == nil {
+		t.Fatalf("unexpected errors: %v", services.errs)
+	}
+
+	// Inactive services and other units aren't listed, active, but not running ones not checked.
+	if services.servicesTotal != 2 {
+		t.Errorf("expected 2 services, got %d", services.servicesTotal)
+	}
+
+	if _, hasStopping := services.services["stopping"]; hasStopping {
+		t.Error("expected the stopping service not to be checked")
+	}
+
+	nginx, hasNginx := services.services["nginx"]
+	if !hasNginx {
+		t.Fatalf("expected nginx, got %v", services.services)
+	}
+
+	if diff := nginx.activeSince.Sub(started); diff < -time.Second || diff > time.Second {
+		t.Errorf("expected nginx to be active since %s, got %s", started, nginx.activeSince)
+	}
+
+	expected := serviceInfo{
+		activeSince:  nginx.activeSince,
+		anyFile:      "/lib/systemd/system/nginx.service",
+		execPaths:    []string{"/usr/sbin/nginx", "/usr/sbin/nginx"},
+		controlGroup: "/system.slice/nginx.service",
+	}
+
+	if !reflect.DeepEqual(nginx, expected) {
+		t.Errorf("expected %#v, got %#v", expected, nginx)
+	}
+}
+
+func TestRestartServiceDBus(t *testing.T) {
+	conn, systemd, stop := startFakeSystemd(t, map[string]fakeUnit{
+		"nginx.service":  {activeState: "active", subState: "running"},
+		"broken.service": {activeState: "active", subState: "running", restartResult: "failed"},
+	})
+	defer stop()
+
+	if context, errRS := restartService(conn, "nginx"); errRS != nil {
+		t.Errorf("%s: %s", context, errRS.Error())
+	}
+
+	if _, errRS := restartService(conn, "broken"); errRS == nil || errRS.Error() != "job failed" {
+		t.Errorf("expected the job to fail, got %v", errRS)
+	}
+
+	systemd.mu.Lock()
+	defer systemd.mu.Unlock()
+
+	if expected := []string{"nginx.service", "broken.service"}; !reflect.DeepEqual(systemd.restarted, expected) {
+		t.Errorf("expected restarts of %v, got %v", expected, systemd.restarted)
+	}
+}
+
+func TestQueryServiceDepsDBus(t *testing.T) {
+	conn, _, stop := startFakeSystemd(t, map[string]fakeUnit{
+		"php-fpm.service": {
+			activeState: "active",
+			subState:    "running",
+			properties: map[string]map[string]dbus.Variant{
+				dbusSystemd + ".Unit": {
+					"Requires":   dbus.MakeVariant([]string{"sysinit.target", "mariadb.service"}),
+					"BindsTo":    dbus.MakeVariant([]string{}),
+					"PartOf":     dbus.MakeVariant([]string{"nginx.service"}),
+					"RequiredBy": dbus.MakeVariant([]string{"multi-user.target", "wordpress.service"}),
+					"BoundBy":    dbus.MakeVariant([]string{}),
+					"ConsistsOf": dbus.MakeVariant([]string{"php-fpm-pool@www.service"}),
+					"After":      dbus.MakeVariant([]string{"network.target", "mariadb.service"}),
+				},
+			},
+		},
+	})
+	defer stop()
+
+	context, deps, errQSD := queryServiceDeps(conn, "php-fpm")
+	if errQSD != nil {
+		t.Fatalf("%s: %s", context, errQSD.Error())
+	}
+
+	expected := serviceDeps{
+		restartedWith: []string{"mariadb", "nginx"},
+		restarts:      []string{"wordpress", "php-fpm-pool@www"},
+		after:         []string{"mariadb"},
+	}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected %#v, got %#v", expected, deps)
+	}
+
+	if _, _, errQSD := queryServiceDeps(conn, "missing"); errQSD == nil {
+		t.Error("expected an error querying a missing unit")
+	}
+}
@@ -2,28 +2,117 @@ package main
 
 import (
 	"bytes"
-	. "github.com/Al2Klimov/go-exec-utils"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
 	"regexp"
 	"strings"
 )
 
-type dpkgShowPackageResult struct {
-	packag  string
-	files   map[string]struct{}
-	deps    map[string]struct{}
-	aliases map[string]struct{}
-	cmd     string
-	err     error
-}
+// dpkgFileLister returns all files of a package, one per line, and the command (or similar) it ran.
+type dpkgFileLister func(name, arch string) (cmd string, lines [][]byte, err error)
 
 var dpkgProperty = regexp.MustCompile(`\A([^=>]+)([=>])(.*)\z`)
+var dpkgStatusField = regexp.MustCompile(`\A([^:\s]+):[ \t]*(.*)\z`)
 var anyWord = regexp.MustCompile(`\S+`)
 var commaSpace = []byte(", ")
+var dpkgUnexpectedLayout = errors.New("unexpected dpkg database layout")
+
+type dpkgBackend struct{}
+
+func init() {
+	registerPackageBackend("dpkg", 10, dpkgBackend{})
+}
+
+func (dpkgBackend) Detect() bool {
+	if _, errStat := os.Stat(path.Join(dpkgAdminDir, "status")); errStat == nil {
+		return true
+	}
+
+	_, errLP := exec.LookPath("dpkg-query")
+	return errLP == nil
+}
+
+func (dpkgBackend) DatabaseFiles() []string {
+	return []string{path.Join(dpkgAdminDir, "status")}
+}
+
+func (dpkgBackend) ShowPackages() (packagesInfo, map[string]error) {
+	return dpkgShowPackages()
+}
 
 func dpkgShowPackages() (packagesInfo, map[string]error) {
-	cmd, rawPackages, errDQ := System(
+	if packages, errs, expected := dpkgReadDatabase(); expected {
+		return packages, errs
+	}
+
+	return dpkgQueryPackages()
+}
+
+// dpkgReadDatabase reads the dpkg database directly
+// unless its layout is unexpected (expected = false).
+func dpkgReadDatabase() (packages packagesInfo, errs map[string]error, expected bool) {
+	rawStatus, errRF := ioutil.ReadFile(path.Join(dpkgAdminDir, "status"))
+	if errRF != nil {
+		return
+	}
+
+	var pending uint64 = 0
+	chDpkgList := make(chan showPackageResult, 64)
+	attrs := map[string][][]byte{}
+	attr := ""
+
+	for _, line := range bytes.Split(rawStatus, lineBreak) {
+		if len(line) < 1 {
+			if dpkgIsInstalled(attrs) {
+				go dpkgShowPackage(attrs, dpkgReadFileList, chDpkgList)
+				pending++
+			}
+
+			attrs = make(map[string][][]byte, 8)
+		} else if line[0] == ' ' || line[0] == '\t' {
+			if attr == "Conffiles" {
+				if match := firstWord.FindSubmatch(line[1:]); match != nil {
+					attrs[attr] = append(attrs[attr], match[1])
+				}
+			}
+		} else if match := dpkgStatusField.FindSubmatch(line); match != nil {
+			attr = string(match[1])
+
+			if attr == "Conffiles" {
+				attrs[attr] = [][]byte{}
+			} else {
+				attrs[attr] = [][]byte{match[2]}
+			}
+		}
+	}
+
+	if dpkgIsInstalled(attrs) {
+		go dpkgShowPackage(attrs, dpkgReadFileList, chDpkgList)
+		pending++
+	}
+
+	attrs = nil
+
+	packages, errs = collectPackages(pending, chDpkgList)
+
+	for _, err := range errs {
+		if err == dpkgUnexpectedLayout {
+			return packagesInfo{}, nil, false
+		}
+	}
+
+	expected = true
+	return
+}
+
+func dpkgQueryPackages() (packagesInfo, map[string]error) {
+	cmd, rawPackages, errDQ := system(
 		"dpkg-query",
 		[]string{
+			"--admindir=" + dpkgAdminDir,
 			"-W",
 			"-f", `Package=${Package}
 Architecture=${Architecture}
@@ -45,7 +134,7 @@ ${Conffiles}
 	}
 
 	var pending uint64 = 0
-	chDpkgList := make(chan dpkgShowPackageResult, 64)
+	chDpkgList := make(chan showPackageResult, 64)
 	attrs := map[string][][]byte{}
 	attr := ""
 	var values [][]byte = nil
@@ -68,11 +157,9 @@ ${Conffiles}
 					attr = string(match[1])
 
 					if attr == "Package" {
-						if _, hasPackage := attrs["Package"]; hasPackage {
-							if _, installed := dpkgParseStatus(attrs["Status"])["installed"]; installed {
-								go dpkgShowPackage(attrs, chDpkgList)
-								pending++
-							}
+						if dpkgIsInstalled(attrs) {
+							go dpkgShowPackage(attrs, dpkgQueryFileList, chDpkgList)
+							pending++
 						}
 
 						attrs = make(map[string][][]byte, 8)
@@ -89,43 +176,24 @@ ${Conffiles}
 		}
 	}
 
-	if _, hasPackage := attrs["Package"]; hasPackage {
-		if _, installed := dpkgParseStatus(attrs["Status"])["installed"]; installed {
-			go dpkgShowPackage(attrs, chDpkgList)
-			pending++
-		}
+	if dpkgIsInstalled(attrs) {
+		go dpkgShowPackage(attrs, dpkgQueryFileList, chDpkgList)
+		pending++
 	}
 
 	attrs = nil
 	values = nil
 
-	packageMetaData := make(map[string]packageInfo, pending)
-	nonConfFiles := map[string]string{}
-	errs := map[string]error{}
-
-	for ; pending > 0; pending-- {
-		if files := <-chDpkgList; files.err == nil {
-			packageMetaData[files.packag] = packageInfo{
-				deps:         files.deps,
-				aliases:      files.aliases,
-				nonConfFiles: files.files,
-			}
-
-			for file := range files.files {
-				nonConfFiles[file] = files.packag
-			}
-		} else {
-			errs[files.cmd] = files.err
-		}
-	}
-
-	close(chDpkgList)
+	return collectPackages(pending, chDpkgList)
+}
 
-	if len(errs) > 0 {
-		return packagesInfo{}, errs
+func dpkgIsInstalled(attrs map[string][][]byte) bool {
+	if _, hasPackage := attrs["Package"]; hasPackage {
+		_, installed := dpkgParseStatus(attrs["Status"])["installed"]
+		return installed
 	}
 
-	return packagesInfo{packages: packageMetaData, nonConfFiles: nonConfFiles}, nil
+	return false
 }
 
 func dpkgParseStatus(status [][]byte) (result map[string]struct{}) {
@@ -142,7 +210,7 @@ func dpkgParseStatus(status [][]byte) (result map[string]struct{}) {
 	return
 }
 
-func dpkgShowPackage(attrs map[string][][]byte, ch chan<- dpkgShowPackageResult) {
+func dpkgShowPackage(attrs map[string][][]byte, listFiles dpkgFileLister, ch chan<- showPackageResult) {
 	arch := dpkgExtractStringAttr(attrs, "Architecture")
 
 	chEffectiveDeps := make(chan map[string]struct{}, 1)
@@ -151,17 +219,17 @@ func dpkgShowPackage(attrs map[string][][]byte, ch chan<- dpkgShowPackageResult)
 	go dpkgParsePackagesLists(chEffectiveDeps, [2][][]byte{attrs["Depends"], attrs["Pre-Depends"]}, []string{arch, "all"})
 	go dpkgParsePackagesLists(chEffectiveAliases, [2][][]byte{attrs["Provides"], attrs["Replaces"]}, []string{arch})
 
-	packag := dpkgExtractStringAttr(attrs, "Package") + ":" + arch
+	name := dpkgExtractStringAttr(attrs, "Package")
+	packag := name + ":" + arch
 
-	cmd, rawFiles, errDL := System("dpkg", []string{"-L", packag}, map[string]string{"LC_ALL": "C"}, "/")
-	if errDL != nil {
+	cmd, lines, errLF := listFiles(name, arch)
+	if errLF != nil {
 		<-chEffectiveDeps
 		<-chEffectiveAliases
-		ch <- dpkgShowPackageResult{cmd: cmd, err: errDL}
+		ch <- showPackageResult{context: cmd, err: errLF}
 		return
 	}
 
-	lines := bytes.Split(rawFiles, lineBreak)
 	files := make(map[string]struct{}, len(lines)-1)
 
 	for _, line := range lines {
@@ -176,16 +244,69 @@ func dpkgShowPackage(attrs map[string][][]byte, ch chan<- dpkgShowPackageResult)
 		delete(files, string(file))
 	}
 
-	ch <- dpkgShowPackageResult{
+	ch <- showPackageResult{
 		packag:  packag,
 		files:   files,
 		deps:    <-chEffectiveDeps,
 		aliases: <-chEffectiveAliases,
-		cmd:     cmd,
+		context: cmd,
 		err:     nil,
 	}
 }
 
+func dpkgQueryFileList(name, arch string) (string, [][]byte, error) {
+	cmd, rawFiles, errDL := system(
+		"dpkg", []string{"--admindir=" + dpkgAdminDir, "-L", name + ":" + arch}, map[string]string{"LC_ALL": "C"}, "/",
+	)
+	if errDL != nil {
+		return cmd, nil, errDL
+	}
+
+	return cmd, bytes.Split(rawFiles, lineBreak), nil
+}
+
+// dpkgReadFileList reads info/PACKAGE:ARCH.list (Multi-Arch: same) or info/PACKAGE.list (others).
+func dpkgReadFileList(name, arch string) (string, [][]byte, error) {
+	info := path.Join(dpkgAdminDir, "info")
+
+	for _, base := range [2]string{path.Join(info, name+":"+arch), path.Join(info, name)} {
+		rawFiles, errRF := ioutil.ReadFile(base + ".list")
+		if errRF != nil {
+			if os.IsNotExist(errRF) {
+				continue
+			}
+
+			return "cat " + base + ".list", nil, errRF
+		}
+
+		lines := bytes.Split(rawFiles, lineBreak)
+
+		if rawConfFiles, errRF := ioutil.ReadFile(base + ".conffiles"); errRF == nil {
+			confFiles := map[string]struct{}{}
+
+			for _, confFile := range bytes.Split(rawConfFiles, lineBreak) {
+				confFiles[string(confFile)] = struct{}{}
+			}
+
+			files := lines[:0]
+
+			for _, line := range lines {
+				if _, isConfFile := confFiles[string(line)]; !isConfFile {
+					files = append(files, line)
+				}
+			}
+
+			lines = files
+		} else if !os.IsNotExist(errRF) {
+			return "cat " + base + ".conffiles", nil, errRF
+		}
+
+		return "cat " + base + ".list", lines, nil
+	}
+
+	return "ls " + path.Join(info, name+"*.list"), nil, dpkgUnexpectedLayout
+}
+
 func dpkgExtractStringAttr(attrs map[string][][]byte, attr string) string {
 	if values := attrs[attr]; len(values) > 0 {
 		return string(values[0])
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const dpkgTestStatus = `Package: nginx
+Status: install ok installed
+Architecture: amd64
+Depends: libc6 (>= 2.34), nginx-common (= 1.22.1-9), libssl3:amd64
+Pre-Depends: dpkg (>= 1.17)
+Provides: httpd
+Conffiles:
+ /etc/nginx/nginx.conf 0123456789abcdef0123456789abcdef
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Multi-Arch: same
+Replaces: libc6-amd64
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+`
+
+// dpkgTestAdminDir creates a dpkg database with the given info/*.list files.
+func dpkgTestAdminDir(t *testing.T, lists map[string]string) string {
+	t.Helper()
+
+	dir, errTD := ioutil.TempDir("", "dpkg")
+	if errTD != nil {
+		t.Fatal(errTD)
+	}
+
+	if errMk := os.Mkdir(path.Join(dir, "info"), 0755); errMk != nil {
+		os.RemoveAll(dir)
+		t.Fatal(errMk)
+	}
+
+	writeFile(t, path.Join(dir, "status"), dpkgTestStatus)
+
+	for base, content := range lists {
+		writeFile(t, path.Join(dir, "info", base), content)
+	}
+
+	return dir
+}
+
+func TestDpkgReadDatabase(t *testing.T) {
+	dir := dpkgTestAdminDir(t, map[string]string{
+		"nginx.list":       "/.\n/usr\n/usr/sbin/nginx\n/etc/nginx/nginx.conf\n/etc/nginx/mime.types\n",
+		"nginx.conffiles":  "/etc/nginx/mime.types\n",
+		"libc6:amd64.list": "/.\n/usr/lib/x86_64-linux-gnu/libc.so.6\n",
+	})
+	defer os.RemoveAll(dir)
+
+	oldAdminDir := dpkgAdminDir
+	dpkgAdminDir = dir
+	defer func() { dpkgAdminDir = oldAdminDir }()
+
+	packages, errs, expected := dpkgReadDatabase()
+	if !expected || errs != nil {
+		t.Fatalf("expected a readable database, got %v (expected layout: %v)", errs, expected)
+	}
+
+	if len(packages.packages) != 2 {
+		t.Errorf("expected only the installed packages, got %v", packages.packages)
+	}
+
+	nginx := packages.packages["nginx:amd64"]
+
+	// Conffiles from both the status and info/*.conffiles are left out.
+	if expected := set("/usr", "/usr/sbin/nginx"); !reflect.DeepEqual(nginx.nonConfFiles, expected) {
+		t.Errorf("files of nginx: expected %v, got %v", expected, nginx.nonConfFiles)
+	}
+
+	expectedDeps := set(
+		"libc6:amd64", "libc6:all", "nginx-common:amd64", "nginx-common:all", "libssl3:amd64", "dpkg:amd64", "dpkg:all",
+	)
+	if !reflect.DeepEqual(nginx.deps, expectedDeps) {
+		t.Errorf("deps of nginx: expected %v, got %v", expectedDeps, nginx.deps)
+	}
+
+	if expected := set("httpd:amd64"); !reflect.DeepEqual(nginx.aliases, expected) {
+		t.Errorf("aliases of nginx: expected %v, got %v", expected, nginx.aliases)
+	}
+
+	if owner := packages.nonConfFiles["/usr/lib/x86_64-linux-gnu/libc.so.6"]; owner != "libc6:amd64" {
+		t.Errorf("owner of libc.so.6: expected libc6:amd64, got %q", owner)
+	}
+}
+
+func TestDpkgReadDatabaseUnexpectedLayout(t *testing.T) {
+	dir := dpkgTestAdminDir(t, map[string]string{"nginx.list": "/usr/sbin/nginx\n"})
+	defer os.RemoveAll(dir)
+
+	oldAdminDir := dpkgAdminDir
+	dpkgAdminDir = dir
+	defer func() { dpkgAdminDir = oldAdminDir }()
+
+	// libc6's list is missing, so dpkg-query shall be asked instead.
+	if _, _, expected := dpkgReadDatabase(); expected {
+		t.Error("expected an unexpected layout")
+	}
+}
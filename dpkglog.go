@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type dpkgLogScan struct {
+	upgrades map[string]time.Time
+	errs     map[string]error
+}
+
+// dpkgLogs are the current and the rotated (optionally gzipped) dpkg logs.
+const dpkgLogs = "/var/log/dpkg.log*"
+
+// dpkgLogUnpack matches e.g. "2024-01-15 10:23:40 upgrade libc6:amd64 2.36-8 2.36-9".
+// Only install and upgrade actions replace a package's files. The later "status installed"
+// (after running the postinst which may restart the service) and trigger processing don't.
+var dpkgLogUnpack = regexp.MustCompile(`\A(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d) (?:install|upgrade) (\S+) `)
+
+// readDpkgLogs returns the time of the latest installation or upgrade of each package ("NAME:ARCH").
+func readDpkgLogs(ch chan<- dpkgLogScan) {
+	files, errGlob := filepath.Glob(dpkgLogs)
+	if errGlob != nil {
+		ch <- dpkgLogScan{errs: map[string]error{dpkgLogs: errGlob}}
+		return
+	}
+
+	chScans := make(chan dpkgLogScan, len(files))
+
+	for _, file := range files {
+		go readDpkgLog(file, chScans)
+	}
+
+	upgrades := map[string]time.Time{}
+	errs := map[string]error{}
+
+	for range files {
+		if scan := <-chScans; scan.errs == nil {
+			for packag, upgrade := range scan.upgrades {
+				if upgrade.After(upgrades[packag]) {
+					upgrades[packag] = upgrade
+				}
+			}
+		} else {
+			for context, err := range scan.errs {
+				errs[context] = err
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		// An unreadable log may lack the latest upgrades, so trust none.
+		ch <- dpkgLogScan{errs: errs}
+	} else {
+		ch <- dpkgLogScan{upgrades: upgrades}
+	}
+}
+
+func readDpkgLog(file string, ch chan<- dpkgLogScan) {
+	if upgrades, errPDL := parseDpkgLog(file); errPDL == nil {
+		ch <- dpkgLogScan{upgrades: upgrades}
+	} else {
+		ch <- dpkgLogScan{errs: map[string]error{file: errPDL}}
+	}
+}
+
+func parseDpkgLog(file string) (map[string]time.Time, error) {
+	f, errOp := os.Open(file)
+	if errOp != nil {
+		return nil, errOp
+	}
+
+	defer f.Close()
+
+	var log io.Reader = f
+
+	if strings.HasSuffix(file, ".gz") {
+		gz, errGz := gzip.NewReader(f)
+		if errGz != nil {
+			return nil, errGz
+		}
+
+		defer gz.Close()
+		log = gz
+	}
+
+	upgrades := map[string]time.Time{}
+	scanner := bufio.NewScanner(log)
+
+	for scanner.Scan() {
+		if match := dpkgLogUnpack.FindStringSubmatch(scanner.Text()); match != nil {
+			// dpkg logs the local time.
+			if upgrade, errPT := time.ParseInLocation("2006-01-02 15:04:05", match[1], time.Local); errPT == nil {
+				if upgrade.After(upgrades[match[2]]) {
+					upgrades[match[2]] = upgrade
+				}
+			}
+		}
+	}
+
+	return upgrades, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const dpkgTestLog = `2024-01-15 10:23:40 startup packages configure
+2024-01-15 10:23:40 upgrade libc6:amd64 2.36-8 2.36-9
+2024-01-15 10:23:41 status half-configured libc6:amd64 2.36-9
+2024-01-15 10:23:42 status installed libc6:amd64 2.36-9
+2024-01-15 10:24:00 trigproc man-db:amd64 2.11.2-2 <none>
+2024-01-16 08:00:00 install nginx:amd64 <none> 1.22.1-9
+2024-01-14 08:00:00 upgrade libc6:amd64 2.36-7 2.36-8
+`
+
+func TestParseDpkgLog(t *testing.T) {
+	dir, errTD := ioutil.TempDir("", "dpkglog")
+	if errTD != nil {
+		t.Fatal(errTD)
+	}
+
+	defer os.RemoveAll(dir)
+
+	plain := path.Join(dir, "dpkg.log")
+	writeFile(t, plain, dpkgTestLog)
+
+	gzipped := path.Join(dir, "dpkg.log.2.gz")
+	f, errCr := os.Create(gzipped)
+	if errCr != nil {
+		t.Fatal(errCr)
+	}
+
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(dpkgTestLog))
+	gz.Close()
+	f.Close()
+
+	// Only the latest installation or upgrade counts, not the status changes afterwards.
+	expected := map[string]time.Time{
+		"libc6:amd64": time.Date(2024, 1, 15, 10, 23, 40, 0, time.Local),
+		"nginx:amd64": time.Date(2024, 1, 16, 8, 0, 0, 0, time.Local),
+	}
+
+	for _, file := range [2]string{plain, gzipped} {
+		upgrades, errPDL := parseDpkgLog(file)
+		if errPDL != nil {
+			t.Errorf("%s: %s", file, errPDL.Error())
+		} else if !reflect.DeepEqual(upgrades, expected) {
+			t.Errorf("%s: expected %v, got %v", file, expected, upgrades)
+		}
+	}
+}
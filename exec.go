@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	. "github.com/Al2Klimov/go-exec-utils"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// operations tracks the running check's context and its unfinished operations.
+var operations = struct {
+	sync.Mutex
+	ctx     context.Context
+	pending map[string]uint64
+}{ctx: context.Background(), pending: map[string]uint64{}}
+
+// workers limits the number of concurrent operations to --parallelism.
+var workers chan struct{}
+
+// beginOperation registers an operation (e.g. a command) until the returned function is called.
+// It waits for a worker and fails once the check has been cancelled.
+func beginOperation(name string) (ctx context.Context, end func(), err error) {
+	operations.Lock()
+	ctx = operations.ctx
+	operations.pending[name]++
+	operations.Unlock()
+
+	unregister := func() {
+		operations.Lock()
+		defer operations.Unlock()
+
+		if operations.pending[name]--; operations.pending[name] < 1 {
+			delete(operations.pending, name)
+		}
+	}
+
+	select {
+	case workers <- struct{}{}:
+	case <-ctx.Done():
+		return ctx, unregister, ctx.Err()
+	}
+
+	return ctx, func() {
+		<-workers
+		unregister()
+	}, nil
+}
+
+// pendingOperations lists the unfinished operations.
+func pendingOperations() []string {
+	operations.Lock()
+	defer operations.Unlock()
+
+	names := make([]string, 0, len(operations.pending))
+	for name := range operations.pending {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// system is like System, but a worker operation which is killed once the check has been cancelled.
+func system(exe string, args []string, env map[string]string, cwd string) (effCmd string, out []byte, err error) {
+	effCmd = FormatCmd(exe, args, env)
+
+	ctx, end, errBO := beginOperation(effCmd)
+	defer end()
+
+	if errBO != nil {
+		return effCmd, nil, errBO
+	}
+
+	cmd := exec.CommandContext(ctx, exe, args...)
+	outBuf := bytes.Buffer{}
+
+	flatEnv := make([]string, 0, len(env))
+	for key, val := range env {
+		flatEnv = append(flatEnv, key+"="+val)
+	}
+
+	cmd.Env = flatEnv
+	cmd.Dir = cwd
+	cmd.Stdin = nil
+	cmd.Stdout = &outBuf
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	return FormatCmd(cmd.Path, args, env), outBuf.Bytes(), err
+}
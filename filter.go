@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern is a glob (path.Match) or, if prefixed with "~", a regular expression.
+type pattern struct {
+	raw   string
+	regex *regexp.Regexp
+}
+
+// patternList collects the values of a repeatable CLI option.
+type patternList []pattern
+
+func (p *patternList) String() string {
+	if p == nil {
+		return ""
+	}
+
+	raw := make([]string, 0, len(*p))
+	for _, pat := range *p {
+		raw = append(raw, pat.raw)
+	}
+
+	return strings.Join(raw, ", ")
+}
+
+func (p *patternList) Set(s string) error {
+	pat := pattern{raw: s}
+
+	if strings.HasPrefix(s, "~") {
+		regex, errRC := regexp.Compile(s[1:])
+		if errRC != nil {
+			return errRC
+		}
+
+		pat.regex = regex
+	} else if _, errMatch := path.Match(s, ""); errMatch != nil {
+		return fmt.Errorf("invalid glob: %q", s)
+	}
+
+	*p = append(*p, pat)
+	return nil
+}
+
+func (p pattern) match(s string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(s)
+	}
+
+	matches, _ := path.Match(p.raw, s)
+	return matches
+}
+
+func (p patternList) match(s string) bool {
+	for _, pat := range p {
+		if pat.match(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceFiltered tells whether a service shall not be checked.
+func serviceFiltered(service string) bool {
+	return (len(includeServices) > 0 && !includeServices.match(service)) || excludeServices.match(service)
+}
+
+// packageFiltered tells whether a package's upgrades shall not make services stale
+// (globally or, due to its policy, for a particular service).
+func packageFiltered(packag string, policy servicePolicy) bool {
+	return packageMatches(excludePackages, packag) || packageMatches(policy.ignorePackages, packag)
+}
+
+// packageMatches also matches dpkg packages (name:arch) by name.
+func packageMatches(patterns patternList, packag string) bool {
+	if patterns.match(packag) {
+		return true
+	}
+
+	if colon := strings.IndexByte(packag, ':'); colon >= 0 {
+		return patterns.match(packag[:colon])
+	}
+
+	return false
+}
+
+// fileIgnored tells whether a file's modification shall not make services stale.
+// Globs also match the file's parent directories, e.g. /usr/share/doc matches /usr/share/doc/bash/README.
+func fileIgnored(file string) bool {
+	if ignoredFile.MatchString(file) {
+		return true
+	}
+
+	for _, pat := range ignorePaths {
+		if pat.regex != nil {
+			if pat.regex.MatchString(file) {
+				return true
+			}
+
+			continue
+		}
+
+		for dir := file; dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if pat.match(dir) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// filterPackages copies deps without the filtered packages.
+func filterPackages(deps map[string]struct{}, policy servicePolicy) map[string]struct{} {
+	if len(excludePackages) < 1 && len(policy.ignorePackages) < 1 {
+		return deps
+	}
+
+	filtered := make(map[string]struct{}, len(deps))
+
+	for dep := range deps {
+		if !packageFiltered(dep, policy) {
+			filtered[dep] = struct{}{}
+		}
+	}
+
+	return filtered
+}
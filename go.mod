@@ -9,7 +9,9 @@ require (
 	github.com/Al2Klimov/go-monplug-utils v0.0.0-20190614130920-37501b5dec90
 	github.com/Al2Klimov/go-pretty-print v0.0.0-20181020210249-508c8cfc87b9
 	github.com/Al2Klimov/go-test-utils v0.0.0-20181021140159-44f0ba1d3916 // indirect
-	golang.org/x/crypto v0.0.0-20191122220453-ac88ee75c92c // indirect
+	github.com/BurntSushi/toml v0.3.0
+	github.com/godbus/dbus/v5 v5.0.6
+	golang.org/x/crypto v0.0.0-20191122220453-ac88ee75c92c
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e // indirect
-	golang.org/x/sys v0.0.0-20210317091845-390168757d9c // indirect
+	golang.org/x/sys v0.0.0-20210317091845-390168757d9c
 )
@@ -0,0 +1,237 @@
+package main
+
+import (
+	pp "github.com/Al2Klimov/go-pretty-print"
+	"html"
+	"strconv"
+	"strings"
+)
+
+type htmlRenderer struct{}
+
+var shortOutput = struct {
+	table [2][]byte
+	tr    [3][]byte
+}{
+	table: [2][]byte{
+		[]byte("<p><b>Some services have not been restarted since some of their parts have been upgraded:</b></p>" +
+			"<table><thead><tr><th>Service</th><th>Packages</th><th>Upgrade - service start</th></tr></thead><tbody>"),
+		[]byte("</tbody></table>\n\n"),
+	},
+	tr: [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
+}
+
+var failuresOutput = struct {
+	ul [2][]byte
+	li [2][]byte
+}{
+	ul: [2][]byte{[]byte("<p><b>" + failuresHeadline + "</b></p><ul>"), []byte("</ul>\n\n")},
+	li: [2][]byte{[]byte("<li>"), []byte("</li>")},
+}
+
+var restartsOutput = struct {
+	table [2][]byte
+	tr    [3][]byte
+}{
+	table: [2][]byte{
+		[]byte("<p><b>" + restartsHeadline + "</b></p>" +
+			"<table><thead><tr><th>Service</th><th>Result</th></tr></thead><tbody>"),
+		[]byte("</tbody></table>\n\n"),
+	},
+	tr: [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
+}
+
+var binaryOutput = struct {
+	table [2][]byte
+	tr    [3][]byte
+}{
+	table: [2][]byte{
+		[]byte("<p><b>Some services run binaries which have been replaced:</b></p>" +
+			"<table><thead><tr><th>Service</th><th>Binary</th></tr></thead><tbody>"),
+		[]byte("</tbody></table>\n\n"),
+	},
+	tr: [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
+}
+
+var longOutput = struct {
+	h1    [2][]byte
+	h2    [2][]byte
+	sev   [2][]byte
+	boot  []byte
+	via   [3][]byte
+	table [2][]byte
+	tr    [3][]byte
+	file  [3][]byte
+}{
+	h1:   [2][]byte{[]byte("<p><b>Service: "), []byte("</b></p>")},
+	h2:   [2][]byte{[]byte("<p>Oldest process: "), []byte("</p>")},
+	sev:  [2][]byte{[]byte("<p>Severity: "), []byte("</p>")},
+	boot: []byte("<p>Reboot the host instead of restarting this service.</p>"),
+	via:  [3][]byte{[]byte("<p>Packages determined via: "), []byte(", "), []byte("</p>")},
+	table: [2][]byte{
+		[]byte("<table><thead><tr><th>Package</th><th>Upgrade - service start</th></tr></thead><tbody>"),
+		[]byte("</tbody></table>"),
+	},
+	tr:   [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
+	file: [3][]byte{[]byte("<tr><td><small>"), []byte("</small></td><td><small>"), []byte("</small></td></tr>")},
+}
+
+func (htmlRenderer) Render(result checkResult) (output string) {
+	if len(result.failures) > 0 {
+		output = assembleFailuresOutput(result.failures)
+	}
+
+	if result.exeUninspectable > 0 {
+		output += "<p>" + exeUninspectableNote(result.exeUninspectable) + "</p>\n\n"
+	}
+
+	if len(result.restarts) > 0 {
+		output += assembleRestartsOutput(result.restarts)
+	}
+
+	if len(result.binariesReplaced) > 0 {
+		output += assembleBinariesOutput(result.binariesReplaced)
+	}
+
+	if len(result.services) > 0 {
+		output += assembleCriticalOutput(result.services)
+	} else {
+		output += "<p>" + noStaleServices + "</p>"
+	}
+
+	return
+}
+
+func assembleFailuresOutput(failures map[string]error) string {
+	builder := strings.Builder{}
+
+	builder.Write(failuresOutput.ul[0])
+
+	for _, failure := range sortedFailures(failures) {
+		builder.Write(failuresOutput.li[0])
+		builder.Write([]byte(html.EscapeString(failure)))
+		builder.Write(failuresOutput.li[1])
+	}
+
+	builder.Write(failuresOutput.ul[1])
+
+	return builder.String()
+}
+
+func assembleCriticalOutput(services []orderedService) string {
+	builder := strings.Builder{}
+
+	builder.Write(shortOutput.table[0])
+
+	for _, service := range services {
+		builder.Write(shortOutput.tr[0])
+		builder.Write([]byte(html.EscapeString(service.name)))
+		builder.Write(shortOutput.tr[1])
+		builder.Write([]byte(strconv.FormatInt(int64(len(service.packages)), 10)))
+		builder.Write(shortOutput.tr[1])
+		builder.Write([]byte(html.EscapeString(pp.Duration(service.packages[0].files[0].diff).String())))
+		builder.Write(shortOutput.tr[2])
+	}
+
+	builder.Write(shortOutput.table[1])
+
+	if verbosity < verbosityPackages {
+		return builder.String()
+	}
+
+	for _, service := range services {
+		builder.Write(longOutput.h1[0])
+		builder.Write([]byte(html.EscapeString(service.name)))
+		builder.Write(longOutput.h1[1])
+
+		if service.pid > 0 {
+			builder.Write(longOutput.h2[0])
+			builder.Write([]byte(strconv.FormatUint(service.pid, 10)))
+			builder.Write(longOutput.h2[1])
+		}
+
+		if service.severity != severityCritical {
+			builder.Write(longOutput.sev[0])
+			builder.Write([]byte(service.severity.String()))
+			builder.Write(longOutput.sev[1])
+		}
+
+		if service.policy.rebootInstead {
+			builder.Write(longOutput.boot)
+		}
+
+		if len(service.mappedVia) > 0 {
+			builder.Write(longOutput.via[0])
+
+			for i, path := range service.mappedVia {
+				if i > 0 {
+					builder.Write(longOutput.via[1])
+				}
+
+				builder.Write([]byte(html.EscapeString(path)))
+			}
+
+			builder.Write(longOutput.via[2])
+		}
+
+		builder.Write(longOutput.table[0])
+
+		for _, packag := range service.packages {
+			builder.Write(longOutput.tr[0])
+			builder.Write([]byte(html.EscapeString(packag.name)))
+			builder.Write(longOutput.tr[1])
+			builder.Write([]byte(html.EscapeString(pp.Duration(packag.files[0].diff).String())))
+			builder.Write(longOutput.tr[2])
+
+			if verbosity >= verbosityFiles {
+				for _, file := range staleFiles(packag) {
+					builder.Write(longOutput.file[0])
+					builder.Write([]byte(html.EscapeString(file.path)))
+					builder.Write(longOutput.file[1])
+					builder.Write([]byte(html.EscapeString(pp.Duration(file.diff).String())))
+					builder.Write(longOutput.file[2])
+				}
+			}
+		}
+
+		builder.Write(longOutput.table[1])
+	}
+
+	return builder.String()
+}
+
+func assembleRestartsOutput(restarts []restartAttempt) string {
+	builder := strings.Builder{}
+
+	builder.Write(restartsOutput.table[0])
+
+	for _, attempt := range restarts {
+		builder.Write(restartsOutput.tr[0])
+		builder.Write([]byte(html.EscapeString(attempt.service)))
+		builder.Write(restartsOutput.tr[1])
+		builder.Write([]byte(html.EscapeString(formatRestartAttempt(attempt))))
+		builder.Write(restartsOutput.tr[2])
+	}
+
+	builder.Write(restartsOutput.table[1])
+
+	return builder.String()
+}
+
+func assembleBinariesOutput(binariesReplaced map[string]string) string {
+	builder := strings.Builder{}
+
+	builder.Write(binaryOutput.table[0])
+
+	for _, service := range sortedServices(binariesReplaced) {
+		builder.Write(binaryOutput.tr[0])
+		builder.Write([]byte(html.EscapeString(service)))
+		builder.Write(binaryOutput.tr[1])
+		builder.Write([]byte(html.EscapeString(binariesReplaced[service])))
+		builder.Write(binaryOutput.tr[2])
+	}
+
+	builder.Write(binaryOutput.table[1])
+
+	return builder.String()
+}
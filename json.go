@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/Al2Klimov/go-monplug-utils"
+	"golang.org/x/crypto/ssh/terminal"
+	"math"
+	"os"
+	"sort"
+)
+
+// jsonSchemaVersion shall be increased on incompatible changes of jsonOutput.
+const jsonSchemaVersion = 1
+
+var statusNames = [4]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type jsonOutput struct {
+	Version   int    `json:"version"`
+	Status    string `json:"status"`
+	ExitCode  int    `json:"exit_code"`
+	Detection string `json:"detection"`
+	// Complete is false if some parts of the host couldn't be inspected.
+	Complete         bool          `json:"complete"`
+	Services         []jsonService `json:"services"`
+	BinariesReplaced []jsonBinary  `json:"binaries_replaced"`
+	// Restarts is null without --restart.
+	Restarts []jsonRestart   `json:"restarts"`
+	Errors   []jsonError     `json:"errors"`
+	Perfdata []jsonPerfdatum `json:"perfdata"`
+}
+
+type jsonService struct {
+	Name          string        `json:"name"`
+	Severity      string        `json:"severity"`
+	RebootInstead bool          `json:"reboot_instead"`
+	OldestPid     *uint64       `json:"oldest_pid"`
+	MappedVia     []string      `json:"mapped_via"`
+	Packages      []jsonPackage `json:"packages"`
+}
+
+type jsonPackage struct {
+	Name  string     `json:"name"`
+	Files []jsonFile `json:"files"`
+}
+
+type jsonFile struct {
+	Path string `json:"path"`
+	// DiffSeconds is the file's mtime minus the service start.
+	DiffSeconds float64 `json:"diff_seconds"`
+}
+
+type jsonBinary struct {
+	Service string `json:"service"`
+	Binary  string `json:"binary"`
+}
+
+type jsonRestart struct {
+	Service string `json:"service"`
+	Result  string `json:"result"`
+	// AlongWith is the service restarting which restarts this one, too.
+	AlongWith *string    `json:"along_with"`
+	Error     *jsonError `json:"error"`
+}
+
+type jsonError struct {
+	Context string `json:"context"`
+	Error   string `json:"error"`
+}
+
+type jsonPerfdatum struct {
+	Label string   `json:"label"`
+	Value *float64 `json:"value"`
+	UOM   string   `json:"uom"`
+	Warn  string   `json:"warn"`
+	Crit  string   `json:"crit"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+}
+
+// executeJSONCheck is like ExecuteCheck, but prints JSON.
+func executeJSONCheck() int {
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(onTerminal())
+		return 3
+	}
+
+	result := runCheck()
+	exit := checkStatus(result)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if encoder.Encode(assembleJSONOutput(result, exit)) != nil {
+		return 3
+	}
+
+	return exit
+}
+
+// checkStatus is the worst status of all perfdata or UNKNOWN on errors.
+// Failures raise it as requested via --on-error.
+func checkStatus(result checkResult) int {
+	if result.errs != nil {
+		return 3
+	}
+
+	status := Ok
+
+	for i := range result.perfdata {
+		if partStatus := result.perfdata[i].GetStatus(); partStatus > status {
+			status = partStatus
+		}
+	}
+
+	if result.failures != nil {
+		switch onError {
+		case "unknown":
+			return 3
+		case "warning":
+			if status < Warning {
+				status = Warning
+			}
+		}
+	}
+
+	return int(status)
+}
+
+func assembleJSONOutput(result checkResult, exit int) jsonOutput {
+	output := jsonOutput{
+		Version:          jsonSchemaVersion,
+		Status:           statusNames[exit],
+		ExitCode:         exit,
+		Detection:        detectionMethod,
+		Complete:         result.errs == nil && result.failures == nil,
+		Services:         make([]jsonService, 0, len(result.services)),
+		BinariesReplaced: make([]jsonBinary, 0, len(result.binariesReplaced)),
+		Errors:           make([]jsonError, 0, len(result.errs)+len(result.failures)),
+		Perfdata:         make([]jsonPerfdatum, 0, len(result.perfdata)),
+	}
+
+	for _, service := range result.services {
+		jService := jsonService{
+			Name:          service.name,
+			Severity:      service.severity.String(),
+			RebootInstead: service.policy.rebootInstead,
+			MappedVia:     service.mappedVia,
+			Packages:      make([]jsonPackage, 0, len(service.packages)),
+		}
+
+		if service.pid > 0 {
+			pid := service.pid
+			jService.OldestPid = &pid
+		}
+
+		if jService.MappedVia == nil {
+			jService.MappedVia = []string{}
+		}
+
+		for _, packag := range service.packages {
+			jPackage := jsonPackage{Name: packag.name, Files: make([]jsonFile, 0, len(packag.files))}
+
+			for _, file := range packag.files {
+				jPackage.Files = append(jPackage.Files, jsonFile{Path: file.path, DiffSeconds: file.diff.Seconds()})
+			}
+
+			jService.Packages = append(jService.Packages, jPackage)
+		}
+
+		output.Services = append(output.Services, jService)
+	}
+
+	for service, binary := range result.binariesReplaced {
+		output.BinariesReplaced = append(output.BinariesReplaced, jsonBinary{Service: service, Binary: binary})
+	}
+
+	sort.Slice(output.BinariesReplaced, func(i, j int) bool {
+		return output.BinariesReplaced[i].Service < output.BinariesReplaced[j].Service
+	})
+
+	if restartStale {
+		output.Restarts = make([]jsonRestart, 0, len(result.restarts))
+
+		for _, attempt := range result.restarts {
+			jRestart := jsonRestart{Service: attempt.service, Result: attempt.action}
+
+			if attempt.parent != "" {
+				parent := attempt.parent
+				jRestart.AlongWith = &parent
+			}
+
+			if attempt.err != nil {
+				jRestart.Error = &jsonError{Context: attempt.context, Error: attempt.err.Error()}
+			}
+
+			output.Restarts = append(output.Restarts, jRestart)
+		}
+	}
+
+	for _, errs := range [2]map[string]error{result.errs, result.failures} {
+		for context, err := range errs {
+			output.Errors = append(output.Errors, jsonError{Context: context, Error: err.Error()})
+		}
+	}
+
+	sort.Slice(output.Errors, func(i, j int) bool {
+		return output.Errors[i].Context < output.Errors[j].Context
+	})
+
+	for _, perfdatum := range result.perfdata {
+		jPerfdatum := jsonPerfdatum{
+			Label: perfdatum.Label,
+			Value: jsonFloat(perfdatum.Value),
+			UOM:   perfdatum.UOM,
+			Warn:  perfdatum.Warn.String(),
+			Crit:  perfdatum.Crit.String(),
+		}
+
+		if perfdatum.Min.IsSet {
+			jPerfdatum.Min = jsonFloat(perfdatum.Min.Value)
+		}
+
+		if perfdatum.Max.IsSet {
+			jPerfdatum.Max = jsonFloat(perfdatum.Max.Value)
+		}
+
+		output.Perfdata = append(output.Perfdata, jPerfdatum)
+	}
+
+	return output
+}
+
+// jsonFloat represents NaN and infinities (which JSON doesn't support) as null.
+func jsonFloat(x float64) *float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil
+	}
+
+	return &x
+}
@@ -3,17 +3,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	. "github.com/Al2Klimov/go-exec-utils"
 	. "github.com/Al2Klimov/go-monplug-utils"
-	pp "github.com/Al2Klimov/go-pretty-print"
-	"html"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/crypto/ssh/terminal"
 	"math"
 	"os"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -27,7 +28,8 @@ type packageInfo struct {
 type packagesInfo struct {
 	packages     map[string]packageInfo
 	nonConfFiles map[string]string
-	errs         map[string]error
+	// errs are fatal if packages is nil, otherwise they concern single packages.
+	errs map[string]error
 }
 
 type nonConfFilesScan struct {
@@ -38,6 +40,7 @@ type nonConfFilesScan struct {
 type mTimesDiff struct {
 	service string
 	diffs   map[string]map[string]time.Duration
+	errs    map[string]error
 }
 
 type orderedFile struct {
@@ -51,9 +54,28 @@ type orderedPackage struct {
 }
 
 type orderedService struct {
-	name     string
-	packages []orderedPackage
-	pending  uint64
+	name      string
+	pid       uint64
+	mappedVia []string
+	policy    servicePolicy
+	severity  severity
+	packages  []orderedPackage
+	pending   uint64
+}
+
+// checkResult is what a check run found out, regardless of the output format.
+type checkResult struct {
+	services         []orderedService
+	binariesReplaced map[string]string
+	// exeUninspectable is the number of services whose executable couldn't be inspected due to missing privileges.
+	exeUninspectable uint64
+	perfdata         PerfdataCollection
+	// errs prevented any result.
+	errs map[string]error
+	// failures prevented inspecting some parts of the host, but not the others.
+	failures map[string]error
+	restarts []restartAttempt
+	duration time.Duration
 }
 
 var firstWord = regexp.MustCompile(`\A(\S+)`)
@@ -61,37 +83,31 @@ var ignoredFile = regexp.MustCompile(`\A/usr/share/(?:doc|man|locale)/`)
 var toleratedFile = regexp.MustCompile(`\A/(?:dev|etc|run|tmp|var)/`)
 var lineBreak = []byte("\n")
 
+// inspectingHost is locked while inspectHost runs.
+var inspectingHost sync.Mutex
+
 var posInf = math.Inf(1)
 var negInf = math.Inf(-1)
 
-var shortOutput = struct {
-	table [2][]byte
-	tr    [3][]byte
-}{
-	table: [2][]byte{
-		[]byte("<p><b>Some services have not been restarted since some of their parts have been upgraded:</b></p>" +
-			"<table><thead><tr><th>Service</th><th>Packages</th><th>Upgrade - service start</th></tr></thead><tbody>"),
-		[]byte("</tbody></table>\n\n"),
-	},
-	tr: [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
-}
+func main() {
+	if !parseOptions() {
+		os.Exit(3)
+	}
 
-var longOutput = struct {
-	h1    [2][]byte
-	h2    [2][]byte
-	table [2][]byte
-	tr    [3][]byte
-}{
-	h1: [2][]byte{[]byte("<p><b>Service: "), []byte("</b></p>")},
-	table: [2][]byte{
-		[]byte("<table><thead><tr><th>Package</th><th>Upgrade - service start</th></tr></thead><tbody>"),
-		[]byte("</tbody></table>"),
-	},
-	tr: [3][]byte{[]byte("<tr><td>"), []byte("</td><td>"), []byte("</td></tr>")},
-}
+	workers = make(chan struct{}, parallelism)
 
-func main() {
-	os.Exit(ExecuteCheck(onTerminal, checkSystemdNeedrestart))
+	if serveMode {
+		os.Exit(serve())
+	}
+
+	switch outputFormat {
+	case "json":
+		os.Exit(executeJSONCheck())
+	case "prometheus":
+		os.Exit(executePrometheusCheck())
+	default:
+		os.Exit(executeCheck())
+	}
 }
 
 func onTerminal() (output string) {
@@ -102,88 +118,182 @@ func onTerminal() (output string) {
 	)
 }
 
-func checkSystemdNeedrestart() (output string, perfdata PerfdataCollection, errs map[string]error) {
+// executeCheck is like ExecuteCheck, but also prints partial results along with failures.
+func executeCheck() int {
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(onTerminal())
+		return 3
+	}
+
+	result := runCheck()
+	if result.errs != nil {
+		for context, err := range result.errs {
+			fmt.Printf("%s: %s\n", context, err.Error())
+		}
+
+		// The services have been restarted nevertheless.
+		if len(result.restarts) > 0 {
+			builder := &strings.Builder{}
+			assembleRestartsText(builder, result.restarts)
+			fmt.Print("\n" + builder.String())
+		}
+
+		return 3
+	}
+
+	if _, errFP := fmt.Print(renderers[outputFormat].Render(result) + result.perfdata.String()); errFP != nil {
+		return 3
+	}
+
+	return checkStatus(result)
+}
+
+// runCheck inspects the host regardless of the output format.
+// After --timeout it cancels all operations and reports the unfinished ones as errors
+// along with the restarts made so far.
+func runCheck() (result checkResult) {
+	start := time.Now()
+	defer func() { result.duration = time.Since(start) }()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	defer cancel()
+
+	ch := make(chan checkResult, 1)
+	made := &restartLog{}
+
+	go func() {
+		// A cancelled predecessor (in serve mode) shall finish first, not to mix up the operations.
+		inspectingHost.Lock()
+		defer inspectingHost.Unlock()
+
+		operations.Lock()
+		operations.ctx = ctx
+		operations.Unlock()
+
+		ch <- checkAndRestart(systemBus(), made)
+	}()
+
+	select {
+	case result = <-ch:
+		return
+	case <-ctx.Done():
+	}
+
+	result.errs = map[string]error{}
+	errTimeout := fmt.Errorf("didn't finish within %s", timeout)
+
+	// Operations which finish during the cancellation haven't finished in time either.
+	for _, operation := range pendingOperations() {
+		result.errs[operation] = errTimeout
+	}
+
+	if len(result.errs) < 1 {
+		result.errs["--timeout"] = fmt.Errorf("the check didn't finish within %s", timeout)
+	}
+
+	// The services have been restarted nevertheless.
+	if result.restarts = made.list(); len(result.restarts) > 0 {
+		var restarted, failed uint64
+
+		for _, attempt := range result.restarts {
+			switch attempt.action {
+			case restartDone:
+				restarted++
+			case restartFailed:
+				failed++
+			}
+		}
+
+		result.perfdata = restartsPerfdata(restarted, failed)
+	}
+
+	return
+}
+
+// inspectHost does the actual work of runCheck.
+// It queries systemd via conn unless it's nil.
+func inspectHost(conn *dbus.Conn) (result checkResult) {
+	if result.errs = loadConfig(); result.errs != nil {
+		return
+	}
+
 	chPackagesInfo := make(chan packagesInfo, 1)
 	chServicesInfo := make(chan servicesInfo, 1)
 
 	go showPackages(chPackagesInfo)
-	go showServices(chServicesInfo)
+	go showServices(conn, chServicesInfo)
 
 	packages := <-chPackagesInfo
 	services := <-chServicesInfo
 
-	if services.errs != nil {
-		errs = services.errs
+	if services.services == nil || packages.packages == nil {
+		result.errs = collectErrs(collectErrs(nil, services.errs), packages.errs)
+		return
 	}
 
-	if packages.errs != nil {
-		if errs == nil {
-			errs = packages.errs
+	result.failures = collectErrs(collectErrs(nil, services.errs), packages.errs)
+
+	policies := map[string]servicePolicy{}
+
+	for name := range services.services {
+		if serviceFiltered(name) {
+			delete(services.services, name)
 		} else {
-			for context, err := range packages.errs {
-				errs[context] = err
-			}
+			policies[name] = policyFor(name)
 		}
 	}
 
-	if errs != nil {
-		return
-	}
-
-	chNonConfFilesScan := make(chan nonConfFilesScan, 64)
 	packagesHandled := map[string]struct{}{}
 	serviceDeps := map[string]map[string]struct{}{}
+	serviceMappings := map[string][]string{}
 
 	for name, service := range services.services {
-		if packag, hasPackage := packages.nonConfFiles[service.anyFile]; hasPackage {
-			deps := packages.packages[packag].deps
+		if deps, mappedVia := findServiceDeps(service, packages); len(mappedVia) > 0 {
+			deps = filterPackages(deps, policies[name])
 			serviceDeps[name] = deps
+			serviceMappings[name] = mappedVia
 
 			for dep := range deps {
-				if _, handled := packagesHandled[dep]; !handled {
-					go scanNonConfFiles(packages.packages[dep].nonConfFiles, chNonConfFilesScan)
-					packagesHandled[dep] = struct{}{}
-				}
-			}
-		}
-	}
-
-	mTimes := map[string]time.Time{}
-	errs = map[string]error{}
-
-	for pending := len(packagesHandled); pending > 0; pending-- {
-		if scan := <-chNonConfFilesScan; scan.errs == nil {
-			for file, mTime := range scan.nonConfFiles {
-				mTimes[file] = mTime
-			}
-		} else {
-			for context, err := range scan.errs {
-				errs[context] = err
+				packagesHandled[dep] = struct{}{}
 			}
 		}
 	}
 
-	if len(errs) > 0 {
-		return
-	}
-
-	errs = nil
-
 	chMTimesDiff := make(chan mTimesDiff, 64)
-
-	for service, deps := range serviceDeps {
-		go diffMTimes(service, services.services[service].activeSince, deps, packages.packages, mTimes, chMTimesDiff)
+	var pendingDiffs int
+
+	switch detectionMethod {
+	case "maps":
+		// Packages are determined via mapped files.
+		serviceMappings = nil
+		pendingDiffs = diffAllMappedFiles(services.services, packages.nonConfFiles, chMTimesDiff)
+	default:
+		var failures map[string]error
+		pendingDiffs, failures = diffAllMTimes(services.services, serviceDeps, packagesHandled, packages.packages, chMTimesDiff)
+		result.failures = collectErrs(result.failures, failures)
 	}
 
 	serviceDiffs := map[string]map[string]map[string]time.Duration{}
-	packagesUpgraded := map[string]struct{}{}
+	stalePackages := map[string]map[string]struct{}{}
+	newestDiffs := map[string]time.Duration{}
 	mTimeDiffMin := float64(posInf)
 	mTimeDiffMax := float64(negInf)
 	mTimeDiffSum := float64(0)
 	mTimeDiffCount := uint64(0)
 
-	for pending := len(serviceDeps); pending > 0; pending-- {
-		if diffs := <-chMTimesDiff; len(diffs.diffs) > 0 {
+	for pending := pendingDiffs; pending > 0; pending-- {
+		diffs := <-chMTimesDiff
+		result.failures = collectErrs(result.failures, diffs.errs)
+
+		if len(diffs.diffs) > 0 {
 			for packag, files := range diffs.diffs {
 				for _, diff := range files {
 					fDiff := float64(diff)
@@ -192,77 +302,361 @@ func checkSystemdNeedrestart() (output string, perfdata PerfdataCollection, errs
 					mTimeDiffSum += fDiff
 					mTimeDiffCount++
 
-					if fDiff >= 0.0 {
+					if fDiff >= 0.0 && graceExpired(services.services[diffs.service].activeSince, diff, policies[diffs.service].gracePeriod) {
 						serviceDiffs[diffs.service] = diffs.diffs
-						packagesUpgraded[packag] = struct{}{}
+
+						if pkgs, hasPkgs := stalePackages[diffs.service]; hasPkgs {
+							pkgs[packag] = struct{}{}
+						} else {
+							stalePackages[diffs.service] = map[string]struct{}{packag: {}}
+						}
+
+						if diff > newestDiffs[diffs.service] {
+							newestDiffs[diffs.service] = diff
+						}
 					}
 				}
 			}
 		}
 	}
 
-	perfdata = PerfdataCollection{
+	binariesReplaced := map[string]string{}
+
+	// Services running replaced binaries are stale, too, subject to the same filters and policies.
+	for name, service := range services.services {
+		if service.exeUninspectable {
+			result.exeUninspectable++
+		}
+
+		if service.exeReplaced == "" || fileIgnored(service.exeReplaced) {
+			continue
+		}
+
+		packag, hasPackage := findPackageOfFile(packages.nonConfFiles, service.exeReplaced)
+		if hasPackage && packageFiltered(packag, policies[name]) {
+			continue
+		}
+
+		// Unless it has vanished, the binary has been replaced after the service start.
+		var diff time.Duration
+		if service.exeChanged.After(service.activeSince) {
+			diff = service.exeChanged.Sub(service.activeSince)
+		}
+
+		if !graceExpired(service.activeSince, diff, policies[name].gracePeriod) {
+			continue
+		}
+
+		binariesReplaced[name] = service.exeReplaced
+
+		// The binary is listed among the service's other stale files (if any), under itself if no package owns it.
+		owner := service.exeReplaced
+		if hasPackage {
+			owner = packag
+		}
+
+		if _, hasDiffs := serviceDiffs[name]; !hasDiffs {
+			serviceDiffs[name] = map[string]map[string]time.Duration{}
+		}
+
+		if fileDiffs, hasOwner := serviceDiffs[name][owner]; hasOwner {
+			fileDiffs[service.exeReplaced] = diff
+		} else {
+			serviceDiffs[name][owner] = map[string]time.Duration{service.exeReplaced: diff}
+		}
+
+		if diff > newestDiffs[name] {
+			newestDiffs[name] = diff
+		}
+
+		if hasPackage {
+			if pkgs, hasPkgs := stalePackages[name]; hasPkgs {
+				pkgs[packag] = struct{}{}
+			} else {
+				stalePackages[name] = map[string]struct{}{packag: {}}
+			}
+		}
+	}
+
+	serviceSeverities := map[string]severity{}
+	servicesStale := map[severity]int{}
+	packagesUpgraded := map[severity]map[string]struct{}{severityWarning: {}, severityCritical: {}}
+
+	for service := range serviceDiffs {
+		sev := staleSeverity(policies[service], services.services[service].activeSince, newestDiffs[service])
+		serviceSeverities[service] = sev
+		servicesStale[sev]++
+
+		if sev != severityOK {
+			for packag := range stalePackages[service] {
+				packagesUpgraded[sev][packag] = struct{}{}
+			}
+		}
+	}
+
+	result.perfdata = PerfdataCollection{
 		Perfdata{
 			Label: "services_active",
 			Value: float64(len(services.services)),
-			Min:   OptionalNumber{true, 0},
-			Max:   OptionalNumber{true, float64(services.servicesTotal)},
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(services.servicesTotal)},
 		},
+		// Only stale services of critical severity may cause CRITICAL.
 		Perfdata{
 			Label: "services_notrestarted",
-			Value: float64(len(serviceDiffs)),
-			Crit:  OptionalThreshold{true, true, 1, posInf},
-			Min:   OptionalNumber{true, 0},
-			Max:   OptionalNumber{true, float64(services.servicesTotal)},
+			Value: float64(servicesStale[severityWarning] + servicesStale[severityCritical]),
+			Warn:  servicesWarning,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(services.servicesTotal)},
+		},
+		Perfdata{
+			Label: "services_notrestarted_critical",
+			Value: float64(servicesStale[severityCritical]),
+			Crit:  servicesCritical,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(services.servicesTotal)},
+		},
+		Perfdata{
+			Label: "services_binary_replaced",
+			Value: float64(len(binariesReplaced)),
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(services.servicesTotal)},
+		},
+		Perfdata{
+			Label: "services_exe_uninspectable",
+			Value: float64(result.exeUninspectable),
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(services.servicesTotal)},
 		},
 		Perfdata{
 			Label: "packages_active",
 			Value: float64(len(packagesHandled)),
-			Min:   OptionalNumber{true, 0},
-			Max:   OptionalNumber{true, float64(len(packages.packages))},
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(len(packages.packages))},
 		},
 		Perfdata{
 			Label: "packages_upgraded",
-			Value: float64(len(packagesUpgraded)),
-			Crit:  OptionalThreshold{true, true, 1, posInf},
-			Min:   OptionalNumber{true, 0},
-			Max:   OptionalNumber{true, float64(len(packages.packages))},
+			Value: float64(len(unionPackages(packagesUpgraded[severityWarning], packagesUpgraded[severityCritical]))),
+			Warn:  packagesWarning,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(len(packages.packages))},
+		},
+		Perfdata{
+			Label: "packages_upgraded_critical",
+			Value: float64(len(packagesUpgraded[severityCritical])),
+			Crit:  packagesCritical,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: float64(len(packages.packages))},
 		},
 		Perfdata{
 			Label: "mtime_diff_min",
 			Value: mTimeDiffMin / float64(time.Microsecond),
 			UOM:   "us",
-			Crit:  OptionalThreshold{true, true, 0, posInf},
 		},
 		Perfdata{
 			Label: "mtime_diff_avg",
 			Value: mTimeDiffSum / float64(mTimeDiffCount) / float64(time.Microsecond),
 			UOM:   "us",
-			Crit:  OptionalThreshold{true, true, 0, posInf},
 		},
 		Perfdata{
 			Label: "mtime_diff_max",
 			Value: mTimeDiffMax / float64(time.Microsecond),
 			UOM:   "us",
-			Crit:  OptionalThreshold{true, true, 0, posInf},
 		},
 	}
 
+	result.perfdata = append(result.perfdata, userServicesPerfdata(services.services, serviceSeverities)...)
+
+	result.binariesReplaced = binariesReplaced
+
 	if len(serviceDiffs) > 0 {
-		output = assembleCriticalOutput(orderCriticalOutput(serviceDiffs))
+		result.services = orderCriticalOutput(serviceDiffs, services.services, serviceMappings, policies, serviceSeverities)
+	}
+
+	return
+}
+
+// findServiceDeps unions the dependencies of the packages owning the service's unit file and executables.
+func findServiceDeps(service serviceInfo, packages packagesInfo) (deps map[string]struct{}, mappedVia []string) {
+	for _, file := range append([]string{service.anyFile}, service.execPaths...) {
+		if packag, hasPackage := findPackageOfFile(packages.nonConfFiles, file); hasPackage {
+			pkgDeps := packages.packages[packag].deps
+
+			switch len(mappedVia) {
+			case 0:
+				deps = pkgDeps
+			case 1:
+				union := make(map[string]struct{}, len(deps)+len(pkgDeps))
+
+				for dep := range deps {
+					union[dep] = struct{}{}
+				}
+
+				deps = union
+				fallthrough
+			default:
+				for dep := range pkgDeps {
+					deps[dep] = struct{}{}
+				}
+			}
+
+			mappedVia = append(mappedVia, file)
+		}
+	}
+
+	return
+}
+
+// graceExpired tells whether a file modified diff after the service start
+// has been modified at least the grace period ago.
+func graceExpired(activeSince time.Time, diff, gracePeriod time.Duration) bool {
+	return time.Since(activeSince.Add(diff)) >= gracePeriod
+}
+
+// staleSeverity downgrades a critical stale service to warning
+// until its newest upgrade is at least the critical-after period old.
+func staleSeverity(policy servicePolicy, activeSince time.Time, newestDiff time.Duration) severity {
+	if policy.severity == severityCritical && !graceExpired(activeSince, newestDiff, policy.criticalAfter) {
+		return severityWarning
+	}
+
+	return policy.severity
+}
+
+// diffAllMTimes starts diffing the mtimes of all services' packages' files.
+// Files which couldn't be stat(2)ed are left out and reported as failures.
+func diffAllMTimes(
+	services map[string]serviceInfo, serviceDeps map[string]map[string]struct{}, packagesHandled map[string]struct{},
+	packages map[string]packageInfo, ch chan mTimesDiff,
+) (pending int, failures map[string]error) {
+	chNonConfFilesScan := make(chan nonConfFilesScan, 64)
+	chDpkgLogScan := make(chan dpkgLogScan, 1)
+
+	if detectionMethod == "dpkglog" {
+		go readDpkgLogs(chDpkgLogScan)
 	} else {
-		output = "<p>No service has not been restarted since some of its parts have been upgraded.</p>"
+		chDpkgLogScan <- dpkgLogScan{}
+	}
+
+	for dep := range packagesHandled {
+		go scanNonConfFiles(packages[dep].nonConfFiles, chNonConfFilesScan)
+	}
+
+	mTimes := map[string]time.Time{}
+
+	for pending := len(packagesHandled); pending > 0; pending-- {
+		scan := <-chNonConfFilesScan
+		failures = collectErrs(failures, scan.errs)
+
+		for file, mTime := range scan.nonConfFiles {
+			mTimes[file] = mTime
+		}
+	}
+
+	dpkgLog := <-chDpkgLogScan
+	failures = collectErrs(failures, dpkgLog.errs)
+
+	// Packages without log entries fall back to their files' mtimes.
+	for packag, upgrade := range dpkgLog.upgrades {
+		if _, isHandled := packagesHandled[packag]; isHandled {
+			for file := range packages[packag].nonConfFiles {
+				if _, hasMTime := mTimes[file]; hasMTime {
+					mTimes[file] = upgrade
+				}
+			}
+		}
 	}
 
+	for service, deps := range serviceDeps {
+		go diffMTimes(service, services[service].activeSince, deps, packages, mTimes, ch)
+	}
+
+	pending = len(serviceDeps)
 	return
 }
 
+// collectErrs adds src to dst, allocating the latter if necessary.
+func collectErrs(dst, src map[string]error) map[string]error {
+	if len(src) > 0 && dst == nil {
+		dst = make(map[string]error, len(src))
+	}
+
+	for context, err := range src {
+		dst[context] = err
+	}
+
+	return dst
+}
+
+func unionPackages(sets ...map[string]struct{}) map[string]struct{} {
+	union := map[string]struct{}{}
+
+	for _, set := range sets {
+		for packag := range set {
+			union[packag] = struct{}{}
+		}
+	}
+
+	return union
+}
+
+// userServicesPerfdata counts the services of each user's service manager.
+func userServicesPerfdata(services map[string]serviceInfo, serviceSeverities map[string]severity) PerfdataCollection {
+	servicesActive := map[string]uint64{}
+	servicesNotRestarted := map[string]uint64{}
+
+	for service := range services {
+		if match := userService.FindStringSubmatch(service); match != nil {
+			servicesActive[match[1]]++
+		}
+	}
+
+	// Like services_notrestarted, only stale services of warning or critical severity count.
+	for service, sev := range serviceSeverities {
+		if sev == severityOK {
+			continue
+		}
+
+		if match := userService.FindStringSubmatch(service); match != nil {
+			servicesNotRestarted[match[1]]++
+		}
+	}
+
+	managers := make([]string, 0, len(servicesActive))
+	for manager := range servicesActive {
+		managers = append(managers, manager)
+	}
+
+	sort.Strings(managers)
+
+	perfdata := make(PerfdataCollection, 0, len(managers)*2)
+
+	for _, manager := range managers {
+		perfdata = append(
+			perfdata,
+			Perfdata{
+				Label: manager + ":services_active",
+				Value: float64(servicesActive[manager]),
+				Min:   OptionalNumber{IsSet: true, Value: 0},
+			},
+			Perfdata{
+				Label: manager + ":services_notrestarted",
+				Value: float64(servicesNotRestarted[manager]),
+				Min:   OptionalNumber{IsSet: true, Value: 0},
+				Max:   OptionalNumber{IsSet: true, Value: float64(servicesActive[manager])},
+			},
+		)
+	}
+
+	return perfdata
+}
+
 func scanNonConfFiles(nonConfFiles map[string]struct{}, ch chan<- nonConfFilesScan) {
 	mTimes := map[string]time.Time{}
 	errs := map[string]error{}
 
 	for file := range nonConfFiles {
-		if ignoredFile.FindSubmatch([]byte(file)) == nil {
+		if !fileIgnored(file) {
 			if info, errStat := os.Lstat(file); errStat == nil {
 				if !info.IsDir() {
 					mTimes[file] = info.ModTime()
@@ -273,11 +667,11 @@ func scanNonConfFiles(nonConfFiles map[string]struct{}, ch chan<- nonConfFilesSc
 		}
 	}
 
-	if len(errs) > 0 {
-		ch <- nonConfFilesScan{errs: errs}
-	} else {
-		ch <- nonConfFilesScan{nonConfFiles: mTimes, errs: nil}
+	if len(errs) < 1 {
+		errs = nil
 	}
+
+	ch <- nonConfFilesScan{nonConfFiles: mTimes, errs: errs}
 }
 
 func diffMTimes(service string, activeSince time.Time, deps map[string]struct{}, packages map[string]packageInfo, mTimes map[string]time.Time, ch chan mTimesDiff) {
@@ -300,7 +694,10 @@ func diffMTimes(service string, activeSince time.Time, deps map[string]struct{},
 	ch <- mTimesDiff{service: service, diffs: diffs}
 }
 
-func orderCriticalOutput(serviceDiffs map[string]map[string]map[string]time.Duration) []orderedService {
+func orderCriticalOutput(
+	serviceDiffs map[string]map[string]map[string]time.Duration, serviceInfos map[string]serviceInfo,
+	serviceMappings map[string][]string, policies map[string]servicePolicy, serviceSeverities map[string]severity,
+) []orderedService {
 	services := make([]orderedService, len(serviceDiffs))
 	serviceIdx := 0
 	pending := uint64(len(services))
@@ -310,7 +707,15 @@ func orderCriticalOutput(serviceDiffs map[string]map[string]map[string]time.Dura
 		packages := make([]orderedPackage, len(packageDiffs))
 		packageIdx := 0
 		serviceAddr := &services[serviceIdx]
-		*serviceAddr = orderedService{name: service, packages: packages, pending: uint64(len(packages))}
+		*serviceAddr = orderedService{
+			name:      service,
+			pid:       serviceInfos[service].oldestPid,
+			mappedVia: serviceMappings[service],
+			policy:    policies[service],
+			severity:  serviceSeverities[service],
+			packages:  packages,
+			pending:   uint64(len(packages)),
+		}
 
 		for packag, fileDiffs := range packageDiffs {
 			files := make([]orderedFile, len(fileDiffs))
@@ -380,40 +785,3 @@ func orderTree(files []orderedFile, packages []orderedPackage, service *orderedS
 		}
 	}
 }
-
-func assembleCriticalOutput(services []orderedService) string {
-	builder := strings.Builder{}
-
-	builder.Write(shortOutput.table[0])
-
-	for _, service := range services {
-		builder.Write(shortOutput.tr[0])
-		builder.Write([]byte(html.EscapeString(service.name)))
-		builder.Write(shortOutput.tr[1])
-		builder.Write([]byte(strconv.FormatInt(int64(len(service.packages)), 10)))
-		builder.Write(shortOutput.tr[1])
-		builder.Write([]byte(html.EscapeString(pp.Duration(service.packages[0].files[0].diff).String())))
-		builder.Write(shortOutput.tr[2])
-	}
-
-	builder.Write(shortOutput.table[1])
-
-	for _, service := range services {
-		builder.Write(longOutput.h1[0])
-		builder.Write([]byte(html.EscapeString(service.name)))
-		builder.Write(longOutput.h1[1])
-		builder.Write(longOutput.table[0])
-
-		for _, packag := range service.packages {
-			builder.Write(longOutput.tr[0])
-			builder.Write([]byte(html.EscapeString(packag.name)))
-			builder.Write(longOutput.tr[1])
-			builder.Write([]byte(html.EscapeString(pp.Duration(packag.files[0].diff).String())))
-			builder.Write(longOutput.tr[2])
-		}
-
-		builder.Write(longOutput.table[1])
-	}
-
-	return builder.String()
-}
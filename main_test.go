@@ -0,0 +1,46 @@
+package main
+
+import (
+	. "github.com/Al2Klimov/go-monplug-utils"
+	"reflect"
+	"testing"
+)
+
+func TestUserServicesPerfdata(t *testing.T) {
+	services := map[string]serviceInfo{
+		"cron":        {},
+		"user@1000:a": {},
+		"user@1000:b": {},
+		"user@1000:c": {},
+		"user@1001:a": {},
+	}
+
+	// Stale services of ok severity don't count as not restarted.
+	serviceSeverities := map[string]severity{
+		"cron":        severityCritical,
+		"user@1000:a": severityWarning,
+		"user@1000:b": severityOK,
+		"user@1001:a": severityOK,
+	}
+
+	expected := PerfdataCollection{
+		Perfdata{Label: "user@1000:services_active", Value: 3, Min: OptionalNumber{IsSet: true, Value: 0}},
+		Perfdata{
+			Label: "user@1000:services_notrestarted",
+			Value: 1,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: 3},
+		},
+		Perfdata{Label: "user@1001:services_active", Value: 1, Min: OptionalNumber{IsSet: true, Value: 0}},
+		Perfdata{
+			Label: "user@1001:services_notrestarted",
+			Value: 0,
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+			Max:   OptionalNumber{IsSet: true, Value: 1},
+		},
+	}
+
+	if actual := userServicesPerfdata(services, serviceSeverities); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	. "github.com/Al2Klimov/go-exec-utils"
+	"os"
+	"time"
+)
+
+func diffAllMappedFiles(services map[string]serviceInfo, nonConfFiles map[string]string, ch chan mTimesDiff) int {
+	for name, service := range services {
+		go diffMappedFiles(name, service, nonConfFiles, ch)
+	}
+
+	return len(services)
+}
+
+// diffMappedFiles looks for replaced files mapped into a service's processes.
+// The diff of such a file is the one between its replacement's mtime (if any) and the service start, but at least 0.
+func diffMappedFiles(service string, info serviceInfo, nonConfFiles map[string]string, ch chan mTimesDiff) {
+	pids, context, errCP := cgroupPids(info.controlGroup)
+	if errCP != nil {
+		ch <- mTimesDiff{service: service, errs: map[string]error{context: errCP}}
+		return
+	}
+
+	policy := policyFor(service)
+	diffs := map[string]map[string]time.Duration{}
+	errs := map[string]error{}
+
+	for _, pid := range pids {
+		files, context, errPMF := procMappedFiles(pid)
+		if errPMF != nil {
+			errs[context] = errPMF
+			continue
+		}
+
+		for file, mapped := range files {
+			if packag, hasPackage := findPackageOfFile(nonConfFiles, file); hasPackage && !packageFiltered(packag, policy) && !fileIgnored(file) {
+				replaced, mTime, errFR := fileReplaced(file, mapped)
+				if errFR != nil {
+					if !(os.IsPermission(errFR) && toleratedFile.MatchString(file)) {
+						errs[FormatCmd("stat", []string{file}, nil)] = errFR
+					}
+
+					continue
+				}
+
+				if replaced {
+					var diff time.Duration
+					if mTime != (time.Time{}) && mTime.After(info.activeSince) {
+						diff = mTime.Sub(info.activeSince)
+					}
+
+					if depDiffs, hasDep := diffs[packag]; hasDep {
+						depDiffs[file] = diff
+					} else {
+						diffs[packag] = map[string]time.Duration{file: diff}
+					}
+				}
+			}
+		}
+	}
+
+	if len(errs) < 1 {
+		errs = nil
+	}
+
+	// The files which could be inspected are still of use.
+	ch <- mTimesDiff{service: service, diffs: diffs, errs: errs}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	. "github.com/Al2Klimov/go-monplug-utils"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var configPath string
+var outputFormat string
+var verbosity int
+var textfile string
+var packageBackend string
+var dpkgAdminDir string
+var detectionMethod string
+var allProcesses bool
+var userServices bool
+var onError string
+var parallelism int
+var timeout time.Duration
+var restartStale bool
+var dryRun bool
+var serveMode bool
+var listen string
+var scanInterval time.Duration
+
+// warningRange and criticalRange apply to both services and packages unless overridden.
+var warningRange OptionalThreshold
+var criticalRange OptionalThreshold
+var servicesWarning OptionalThreshold
+var servicesCritical OptionalThreshold
+var packagesWarning OptionalThreshold
+var packagesCritical OptionalThreshold
+var gracePeriod time.Duration
+var criticalAfter time.Duration
+
+var includeServices patternList
+var excludeServices patternList
+var excludePackages patternList
+var ignorePaths patternList
+var neverRestart patternList
+
+// thresholdFlag works around OptionalThreshold.Set's error which recurses infinitely on Error().
+type thresholdFlag struct {
+	*OptionalThreshold
+}
+
+func (t thresholdFlag) String() string {
+	if t.OptionalThreshold == nil {
+		return ""
+	}
+
+	return t.OptionalThreshold.String()
+}
+
+func (t thresholdFlag) Set(s string) error {
+	if t.OptionalThreshold.Set(s) != nil {
+		return fmt.Errorf("invalid threshold: %q", s)
+	}
+
+	return nil
+}
+
+// parseOptions parses the CLI arguments and tells whether they're valid.
+func parseOptions() bool {
+	args := os.Args[1:]
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
+	if len(args) > 0 && args[0] == "serve" {
+		serveMode = true
+		args = args[1:]
+
+		flag.StringVar(&listen, "listen", "localhost:9847", "serve on this TCP address or unix:/path/to/socket")
+		flag.DurationVar(&scanInterval, "interval", 5*time.Minute, "rescan this often")
+	}
+
+	flag.StringVar(&configPath, "config", "", "config file (default "+defaultConfigFile+" if it exists)")
+	flag.StringVar(&outputFormat, "format", "html", "output format: html (for monitoring UIs), text, json, prometheus")
+
+	flag.StringVar(
+		&textfile, "textfile", "",
+		"write the prometheus format atomically to this file (e.g. for node_exporter's textfile collector)",
+	)
+
+	flag.IntVar(
+		&verbosity, "verbosity", verbosityPackages,
+		"details about stale services: 0 (summary), 1 (packages), 2 (packages and files)",
+	)
+
+	flag.StringVar(&packageBackend, "package-backend", "", "force a package backend (dpkg, rpm, pacman, apk) instead of auto-detecting one")
+	flag.StringVar(&dpkgAdminDir, "admindir", "/var/lib/dpkg", "dpkg database directory")
+
+	flag.StringVar(
+		&detectionMethod, "detection", "mtime",
+		"how to detect services to be restarted: mtime (file modification times), maps (replaced files in /proc/PID/maps), "+
+			"dpkglog (upgrade times from /var/log/dpkg.log*, mtimes as fallback)",
+	)
+
+	flag.BoolVar(
+		&allProcesses, "all-processes", false,
+		"consider the start time of every process of a service, not just the main one's",
+	)
+
+	flag.BoolVar(&userServices, "user-services", false, "also check the services of all users' service managers")
+
+	flag.StringVar(
+		&onError, "on-error", "unknown",
+		"status if some parts of the host couldn't be inspected: unknown, warning, ignore (just report them)",
+	)
+
+	flag.Var(
+		thresholdFlag{&warningRange}, "warning",
+		"warning range for the numbers of services not restarted and upgraded packages (default @1: for services)",
+	)
+
+	flag.Var(
+		thresholdFlag{&criticalRange}, "critical",
+		"critical range for the numbers of services not restarted and upgraded packages of critical severity (default @1:)",
+	)
+
+	flag.Var(thresholdFlag{&servicesWarning}, "warning-services", "like -warning, but only for services")
+	flag.Var(thresholdFlag{&servicesCritical}, "critical-services", "like -critical, but only for services")
+	flag.Var(thresholdFlag{&packagesWarning}, "warning-packages", "like -warning, but only for packages")
+	flag.Var(thresholdFlag{&packagesCritical}, "critical-packages", "like -critical, but only for packages")
+
+	flag.DurationVar(
+		&gracePeriod, "grace-period", 0,
+		"don't consider files modified less than this ago (e.g. 2h30m) to make services stale",
+	)
+
+	flag.DurationVar(
+		&criticalAfter, "critical-after", 0,
+		"count stale services as warning (not critical) until their newest upgrade is this old (e.g. 12h)",
+	)
+
+	flag.IntVar(&parallelism, "parallelism", runtime.NumCPU()*2, "run at most this many commands and D-Bus calls at once")
+
+	flag.DurationVar(
+		&timeout, "timeout", 50*time.Second,
+		"abort (killing all commands) and report what didn't finish after this (0 = never)",
+	)
+
+	flag.Var(&includeServices, "include-service", "only check services matching this glob or ~regex (repeatable)")
+	flag.Var(&excludeServices, "exclude-service", "don't check services matching this glob or ~regex (repeatable)")
+	flag.Var(&excludePackages, "exclude-package", "ignore upgrades of packages matching this glob or ~regex (repeatable)")
+
+	flag.Var(
+		&ignorePaths, "ignore-path",
+		"ignore modifications of files matching this glob (or being in such a directory) or ~regex (repeatable)",
+	)
+
+	flag.BoolVar(&restartStale, "restart", false, "restart stale services (except ok ones) and re-run the check to verify")
+	flag.BoolVar(&dryRun, "dry-run", false, "with -restart: only show which services would be restarted")
+
+	for _, raw := range defaultNeverRestart {
+		neverRestart.Set(raw)
+	}
+
+	flag.Var(
+		&neverRestart, "never-restart",
+		"with -restart: never restart services matching this glob or ~regex (repeatable, in addition to "+
+			strings.Join(defaultNeverRestart, ", ")+")",
+	)
+
+	if flag.CommandLine.Parse(args) != nil {
+		return false
+	}
+
+	if flag.NArg() > 0 {
+		fmt.Printf("unexpected argument: %q\n", flag.Arg(0))
+		return false
+	}
+
+	switch detectionMethod {
+	case "mtime", "maps", "dpkglog":
+	default:
+		fmt.Printf("invalid value %q for flag -detection\n", detectionMethod)
+		return false
+	}
+
+	if detectionMethod == "dpkglog" && packageBackend != "" && packageBackend != "dpkg" {
+		fmt.Println("flag -detection dpkglog requires -package-backend dpkg")
+		return false
+	}
+
+	if parallelism < 1 {
+		fmt.Printf("invalid value %d for flag -parallelism\n", parallelism)
+		return false
+	}
+
+	if timeout < 0 {
+		fmt.Printf("invalid value %s for flag -timeout\n", timeout)
+		return false
+	}
+
+	switch onError {
+	case "unknown", "warning", "ignore":
+	default:
+		fmt.Printf("invalid value %q for flag -on-error\n", onError)
+		return false
+	}
+
+	switch outputFormat {
+	case "json", "prometheus":
+	default:
+		if _, isRenderer := renderers[outputFormat]; !isRenderer {
+			fmt.Printf("invalid value %q for flag -format\n", outputFormat)
+			return false
+		}
+	}
+
+	if dryRun && !restartStale {
+		fmt.Println("flag -dry-run requires -restart")
+		return false
+	}
+
+	if serveMode && restartStale {
+		fmt.Println("flag -restart can't be used with serve")
+		return false
+	}
+
+	if serveMode && textfile != "" {
+		fmt.Println("flag -textfile can't be used with serve")
+		return false
+	}
+
+	if textfile != "" && outputFormat != "prometheus" {
+		fmt.Println("flag -textfile requires -format prometheus")
+		return false
+	}
+
+	if serveMode && scanInterval <= 0 {
+		fmt.Printf("invalid value %s for flag -interval\n", scanInterval)
+		return false
+	}
+
+	if verbosity < verbositySummary || verbosity > verbosityFiles {
+		fmt.Printf("invalid value %d for flag -verbosity\n", verbosity)
+		return false
+	}
+
+	staleAlert := OptionalThreshold{IsSet: true, Inverted: true, Start: 1, End: posInf}
+
+	servicesWarning = firstThreshold(servicesWarning, warningRange, staleAlert)
+	servicesCritical = firstThreshold(servicesCritical, criticalRange, staleAlert)
+	packagesWarning = firstThreshold(packagesWarning, warningRange)
+	packagesCritical = firstThreshold(packagesCritical, criticalRange, staleAlert)
+
+	return true
+}
+
+// firstThreshold returns the first set one of thresholds (if any).
+func firstThreshold(thresholds ...OptionalThreshold) OptionalThreshold {
+	for _, threshold := range thresholds {
+		if threshold.IsSet {
+			return threshold
+		}
+	}
+
+	return OptionalThreshold{}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+)
+
+const pacmanLocalDb = "/var/lib/pacman/local"
+
+var pacmanSection = regexp.MustCompile(`\A%([A-Z0-9]+)%\z`)
+var pacmanPackageName = regexp.MustCompile(`\A([^<>=:]+)`)
+
+type pacmanBackend struct{}
+
+func init() {
+	registerPackageBackend("pacman", 20, pacmanBackend{})
+}
+
+func (pacmanBackend) Detect() bool {
+	info, errStat := os.Stat(pacmanLocalDb)
+	return errStat == nil && info.IsDir()
+}
+
+// DatabaseFiles includes the directory of all packages' directories
+// which are renamed (i.e. the directory is modified) on upgrades.
+func (pacmanBackend) DatabaseFiles() []string {
+	return []string{pacmanLocalDb}
+}
+
+func (pacmanBackend) ShowPackages() (packagesInfo, map[string]error) {
+	return pacmanShowPackages()
+}
+
+func pacmanShowPackages() (packagesInfo, map[string]error) {
+	entries, errRD := ioutil.ReadDir(pacmanLocalDb)
+	if errRD != nil {
+		return packagesInfo{}, map[string]error{"ls " + pacmanLocalDb: errRD}
+	}
+
+	var pending uint64 = 0
+	chPacmanList := make(chan showPackageResult, 64)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			go pacmanShowPackage(path.Join(pacmanLocalDb, entry.Name()), chPacmanList)
+			pending++
+		}
+	}
+
+	return collectPackages(pending, chPacmanList)
+}
+
+func pacmanShowPackage(dir string, ch chan<- showPackageResult) {
+	descFile := path.Join(dir, "desc")
+
+	rawDesc, errRF := ioutil.ReadFile(descFile)
+	if errRF != nil {
+		ch <- showPackageResult{context: "cat " + descFile, err: errRF}
+		return
+	}
+
+	filesFile := path.Join(dir, "files")
+
+	rawFiles, errRF := ioutil.ReadFile(filesFile)
+	if errRF != nil {
+		ch <- showPackageResult{context: "cat " + filesFile, err: errRF}
+		return
+	}
+
+	desc := pacmanParseSections(rawDesc)
+	files := pacmanParseSections(rawFiles)
+	nonConfFiles := make(map[string]struct{}, len(files["FILES"]))
+
+	for _, file := range files["FILES"] {
+		// Directories end with a slash.
+		if file[len(file)-1] != '/' {
+			nonConfFiles["/"+string(file)] = struct{}{}
+		}
+	}
+
+	for _, file := range files["BACKUP"] {
+		if match := firstWord.FindSubmatch(file); match != nil {
+			delete(nonConfFiles, "/"+string(match[1]))
+		}
+	}
+
+	packag := ""
+	if names := desc["NAME"]; len(names) > 0 {
+		packag = string(names[0])
+	}
+
+	ch <- showPackageResult{
+		packag:  packag,
+		files:   nonConfFiles,
+		deps:    pacmanParsePackagesList(desc["DEPENDS"]),
+		aliases: pacmanParsePackagesList(append(desc["PROVIDES"], desc["REPLACES"]...)),
+		context: dir,
+		err:     nil,
+	}
+}
+
+func pacmanParseSections(raw []byte) map[string][][]byte {
+	sections := map[string][][]byte{}
+	section := ""
+	var values [][]byte = nil
+
+	for _, line := range bytes.Split(raw, lineBreak) {
+		if values == nil {
+			if match := pacmanSection.FindSubmatch(line); match != nil {
+				section = string(match[1])
+				values = [][]byte{}
+			}
+		} else if len(line) > 0 {
+			values = append(values, line)
+		} else {
+			sections[section] = values
+			values = nil
+		}
+	}
+
+	if values != nil {
+		sections[section] = values
+	}
+
+	return sections
+}
+
+func pacmanParsePackagesList(packages [][]byte) map[string]struct{} {
+	result := make(map[string]struct{}, len(packages))
+
+	for _, packag := range packages {
+		if match := pacmanPackageName.FindSubmatch(packag); match != nil {
+			result[string(match[1])] = struct{}{}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestPacmanShowPackage(t *testing.T) {
+	dir, errTD := ioutil.TempDir("", "pacman")
+	if errTD != nil {
+		t.Fatal(errTD)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeFile(t, path.Join(dir, "desc"), `%NAME%
+openssh
+
+%VERSION%
+9.6p1-1
+
+%DEPENDS%
+glibc
+openssl>=3
+libxcrypt:libcrypt.so=2-64
+
+%PROVIDES%
+ssh=9.6
+
+%REPLACES%
+openssh-hpn
+`)
+
+	writeFile(t, path.Join(dir, "files"), `%FILES%
+etc/
+etc/ssh/
+etc/ssh/sshd_config
+usr/
+usr/bin/sshd
+
+%BACKUP%
+etc/ssh/sshd_config	d41d8cd98f00b204e9800998ecf8427e
+`)
+
+	ch := make(chan showPackageResult, 1)
+	pacmanShowPackage(dir, ch)
+	result := <-ch
+
+	if result.err != nil {
+		t.Fatalf("%s: %s", result.context, result.err.Error())
+	}
+
+	if result.packag != "openssh" {
+		t.Errorf("expected package openssh, got %q", result.packag)
+	}
+
+	// Directories and backup files are left out.
+	if expected := set("/usr/bin/sshd"); !reflect.DeepEqual(result.files, expected) {
+		t.Errorf("expected files %v, got %v", expected, result.files)
+	}
+
+	if expected := set("glibc", "openssl", "libxcrypt"); !reflect.DeepEqual(result.deps, expected) {
+		t.Errorf("expected deps %v, got %v", expected, result.deps)
+	}
+
+	if expected := set("ssh", "openssh-hpn"); !reflect.DeepEqual(result.aliases, expected) {
+		t.Errorf("expected aliases %v, got %v", expected, result.aliases)
+	}
+}
+
+func TestPacmanShowPackageMissing(t *testing.T) {
+	ch := make(chan showPackageResult, 1)
+	pacmanShowPackage("/nonexistent", ch)
+
+	if result := <-ch; result.err == nil || result.context != "cat /nonexistent/desc" {
+		t.Errorf("expected an error reading /nonexistent/desc, got %q: %v", result.context, result.err)
+	}
+}
+
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+
+	if errWF := ioutil.WriteFile(file, []byte(content), 0644); errWF != nil {
+		t.Fatal(errWF)
+	}
+}
@@ -1,10 +1,171 @@
 package main
 
-import "sync/atomic"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PackageBackend provides the installed packages of a package manager.
+type PackageBackend interface {
+	// Detect tells whether the package manager manages this host.
+	Detect() bool
+
+	// DatabaseFiles are modified whenever packages are (un)installed or upgraded.
+	DatabaseFiles() []string
+
+	// ShowPackages lists the installed packages with their non-config files,
+	// dependencies and provided aliases (e.g. virtual packages).
+	// On errors with single packages it shall still return all the other ones.
+	ShowPackages() (packagesInfo, map[string]error)
+}
+
+// showPackageResult is a single package read by a backend.
+type showPackageResult struct {
+	packag  string
+	files   map[string]struct{}
+	deps    map[string]struct{}
+	aliases map[string]struct{}
+	context string
+	err     error
+}
+
+type registeredPackageBackend struct {
+	name     string
+	priority int
+	backend  PackageBackend
+}
+
+// packageBackends are ordered by priority, so auto-detection prefers the lowest one.
+var packageBackends []registeredPackageBackend
+
+var noPackageBackend = errors.New("no supported package manager found")
+var dpkgLogWithoutDpkg = errors.New("the package manager isn't dpkg")
+
+// packagesCache holds the last packages read unless their database has been modified since then.
+var packagesCache struct {
+	sync.Mutex
+	state    string
+	packages packagesInfo
+}
+
+// resetPackagesCache makes the next showPackages read the packages again.
+func resetPackagesCache() {
+	packagesCache.Lock()
+	defer packagesCache.Unlock()
+
+	packagesCache.state = ""
+	packagesCache.packages = packagesInfo{}
+}
+
+func registerPackageBackend(name string, priority int, backend PackageBackend) {
+	packageBackends = append(packageBackends, registeredPackageBackend{name: name, priority: priority, backend: backend})
+
+	sort.SliceStable(packageBackends, func(i, j int) bool {
+		return packageBackends[i].priority < packageBackends[j].priority
+	})
+}
+
+func findPackageBackend(name string) (PackageBackend, error) {
+	for _, backend := range packageBackends {
+		if name == "" {
+			if backend.backend.Detect() {
+				return backend.backend, nil
+			}
+		} else if backend.name == name {
+			return backend.backend, nil
+		}
+	}
+
+	if name == "" {
+		return nil, noPackageBackend
+	}
+
+	return nil, fmt.Errorf("no such package backend: %q", name)
+}
+
+// findPackageOfFile also considers merged /usr (e.g. /lib/x vs. /usr/lib/x).
+// Non-absolute paths (e.g. the empty FragmentPath of a unit without unit file) have no package.
+func findPackageOfFile(nonConfFiles map[string]string, file string) (string, bool) {
+	if !strings.HasPrefix(file, "/") {
+		return "", false
+	}
+
+	if packag, hasPackage := nonConfFiles[file]; hasPackage {
+		return packag, true
+	}
+
+	if strings.HasPrefix(file, "/usr/") {
+		file = file[4:]
+	} else {
+		file = "/usr" + file
+	}
+
+	packag, hasPackage := nonConfFiles[file]
+	return packag, hasPackage
+}
+
+// collectPackages receives pending packages from ch and closes it.
+func collectPackages(pending uint64, ch chan showPackageResult) (packagesInfo, map[string]error) {
+	packageMetaData := make(map[string]packageInfo, pending)
+	nonConfFiles := map[string]string{}
+	errs := map[string]error{}
+
+	for ; pending > 0; pending-- {
+		if files := <-ch; files.err == nil {
+			packageMetaData[files.packag] = packageInfo{
+				deps:         files.deps,
+				aliases:      files.aliases,
+				nonConfFiles: files.files,
+			}
+
+			for file := range files.files {
+				nonConfFiles[file] = files.packag
+			}
+		} else {
+			errs[files.context] = files.err
+		}
+	}
+
+	close(ch)
+
+	if len(errs) > 0 {
+		// The packages which could be read are still of use.
+		return packagesInfo{packages: packageMetaData, nonConfFiles: nonConfFiles}, errs
+	}
+
+	return packagesInfo{packages: packageMetaData, nonConfFiles: nonConfFiles}, nil
+}
 
 func showPackages(ch chan<- packagesInfo) {
-	packages, errs := dpkgShowPackages()
-	if errs != nil {
+	backend, errFPB := findPackageBackend(packageBackend)
+	if errFPB != nil {
+		ch <- packagesInfo{errs: map[string]error{"--package-backend": errFPB}}
+		return
+	}
+
+	// Otherwise it would silently behave like mtime.
+	if _, isDpkg := backend.(dpkgBackend); detectionMethod == "dpkglog" && !isDpkg {
+		ch <- packagesInfo{errs: map[string]error{"--detection dpkglog": dpkgLogWithoutDpkg}}
+		return
+	}
+
+	state := packageDatabaseState(backend)
+
+	packagesCache.Lock()
+	defer packagesCache.Unlock()
+
+	if packagesCache.state == state {
+		ch <- packagesCache.packages
+		return
+	}
+
+	packages, errs := backend.ShowPackages()
+	if packages.packages == nil {
 		ch <- packagesInfo{errs: errs}
 		return
 	}
@@ -135,9 +296,33 @@ func showPackages(ch chan<- packagesInfo) {
 		pkgInfo.deps[packag] = struct{}{}
 	}
 
+	if errs == nil {
+		packagesCache.state = state
+		packagesCache.packages = packages
+	} else {
+		packages.errs = errs
+	}
+
 	ch <- packages
 }
 
+// packageDatabaseState identifies the state of a package backend's database by its files' mtimes and sizes.
+func packageDatabaseState(backend PackageBackend) string {
+	builder := strings.Builder{}
+
+	fmt.Fprintf(&builder, "%T", backend)
+
+	for _, file := range backend.DatabaseFiles() {
+		if info, errStat := os.Stat(file); errStat == nil {
+			fmt.Fprintf(&builder, "\n%s %d %d", file, info.ModTime().UnixNano(), info.Size())
+		} else {
+			fmt.Fprintf(&builder, "\n%s -", file)
+		}
+	}
+
+	return builder.String()
+}
+
 func unaliasDeps(deps map[string]struct{}, aliases map[string]map[string]struct{}, pending *uint64, chDone chan<- struct{}) {
 	newDeps := map[string]struct{}{}
 
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fakeBackend serves fixed packages, so showPackages can be tested without a package manager.
+type fakeBackend struct {
+	packages map[string]packageInfo
+	errs     map[string]error
+}
+
+func (*fakeBackend) Detect() bool {
+	return false
+}
+
+func (*fakeBackend) DatabaseFiles() []string {
+	return nil
+}
+
+func (f *fakeBackend) ShowPackages() (packagesInfo, map[string]error) {
+	// showPackages modifies the packages.
+	packages := make(map[string]packageInfo, len(f.packages))
+	nonConfFiles := map[string]string{}
+
+	for name, pkgInfo := range f.packages {
+		packages[name] = packageInfo{
+			deps:         copySet(pkgInfo.deps),
+			aliases:      copySet(pkgInfo.aliases),
+			nonConfFiles: copySet(pkgInfo.nonConfFiles),
+		}
+
+		for file := range pkgInfo.nonConfFiles {
+			nonConfFiles[file] = name
+		}
+	}
+
+	return packagesInfo{packages: packages, nonConfFiles: nonConfFiles}, f.errs
+}
+
+var fake = &fakeBackend{}
+
+func init() {
+	registerPackageBackend("fake", 1000, fake)
+}
+
+func copySet(set map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{}, len(set))
+	for item := range set {
+		result[item] = struct{}{}
+	}
+
+	return result
+}
+
+func set(items ...string) map[string]struct{} {
+	result := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		result[item] = struct{}{}
+	}
+
+	return result
+}
+
+// showFakePackages runs showPackages on the fake backend.
+func showFakePackages(t *testing.T, packages map[string]packageInfo, errs map[string]error) packagesInfo {
+	t.Helper()
+
+	oldBackend := packageBackend
+	packageBackend = "fake"
+	defer func() { packageBackend = oldBackend }()
+
+	fake.packages = packages
+	fake.errs = errs
+	resetPackagesCache()
+
+	ch := make(chan packagesInfo, 1)
+	showPackages(ch)
+	return <-ch
+}
+
+func TestShowPackages(t *testing.T) {
+	packages := showFakePackages(t, map[string]packageInfo{
+		"nginx": {
+			deps:         set("libssl", "www-server-common", "missing"),
+			nonConfFiles: set("/usr/sbin/nginx"),
+		},
+		"libssl3": {
+			deps:         set("libc"),
+			aliases:      set("libssl"),
+			nonConfFiles: set("/usr/lib/libssl.so.3"),
+		},
+		"libc": {
+			nonConfFiles: set("/usr/lib/libc.so.6"),
+		},
+		"nginx-common": {
+			aliases: set("www-server-common"),
+		},
+		"unrelated": {
+			nonConfFiles: set("/usr/bin/unrelated"),
+		},
+	}, nil)
+
+	if packages.errs != nil {
+		t.Fatalf("unexpected errors: %v", packages.errs)
+	}
+
+	expected := map[string]map[string]struct{}{
+		// Aliases are resolved, unknown packages are dropped and dependencies are transitive.
+		"nginx":        set("nginx", "libssl3", "libc", "nginx-common"),
+		"libssl3":      set("libssl3", "libc"),
+		"libc":         set("libc"),
+		"nginx-common": set("nginx-common"),
+		"unrelated":    set("unrelated"),
+	}
+
+	for name, deps := range expected {
+		if actual := packages.packages[name].deps; !reflect.DeepEqual(actual, deps) {
+			t.Errorf("deps of %s: expected %v, got %v", name, deps, actual)
+		}
+	}
+
+	if owner := packages.nonConfFiles["/usr/lib/libssl.so.3"]; owner != "libssl3" {
+		t.Errorf("owner of /usr/lib/libssl.so.3: expected libssl3, got %q", owner)
+	}
+}
+
+func TestShowPackagesCache(t *testing.T) {
+	showFakePackages(t, map[string]packageInfo{"old": {}}, nil)
+	packages := showFakePackages(t, map[string]packageInfo{"new": {}}, nil)
+
+	if _, hasNew := packages.packages["new"]; !hasNew || len(packages.packages) != 1 {
+		t.Errorf("expected only the new package, got %v", packages.packages)
+	}
+}
+
+func TestShowPackagesPartial(t *testing.T) {
+	errs := map[string]error{"cat /broken": dpkgUnexpectedLayout}
+	packages := showFakePackages(t, map[string]packageInfo{"good": {}}, errs)
+
+	if _, hasGood := packages.packages["good"]; !hasGood {
+		t.Errorf("expected the readable package, got %v", packages.packages)
+	}
+
+	if !reflect.DeepEqual(packages.errs, errs) {
+		t.Errorf("expected errors %v, got %v", errs, packages.errs)
+	}
+}
+
+func TestShowPackagesDpkgLog(t *testing.T) {
+	oldMethod := detectionMethod
+	detectionMethod = "dpkglog"
+	defer func() { detectionMethod = oldMethod }()
+
+	packages := showFakePackages(t, map[string]packageInfo{"good": {}}, nil)
+
+	if packages.packages != nil || packages.errs["--detection dpkglog"] != dpkgLogWithoutDpkg {
+		t.Errorf("expected dpkglog to be rejected, got %v", packages)
+	}
+}
+
+func TestFindPackageOfFile(t *testing.T) {
+	// Packages may own directories like /usr.
+	nonConfFiles := map[string]string{"/usr/lib/x": "merged", "/bin/sh": "dash", "/usr": "filesystem"}
+
+	for file, expected := range map[string]string{
+		"/usr/lib/x":  "merged",
+		"/lib/x":      "merged",
+		"/usr/bin/sh": "dash",
+		"/lib/y":      "",
+		"":            "",
+		"lib/x":       "",
+	} {
+		if actual, _ := findPackageOfFile(nonConfFiles, file); actual != expected {
+			t.Errorf("findPackageOfFile(%q): expected %q, got %q", file, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/Al2Klimov/go-exec-utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type mappedFile struct {
+	dev     uint64
+	inode   uint64
+	deleted bool
+}
+
+const exeDeleted = " (deleted)"
+
+// userHz is the unit of /proc/PID/stat times.
+const userHz = 100
+
+var badProcStat = errors.New("can't parse process status")
+var cgroupMounts = []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified", "/sys/fs/cgroup/systemd"}
+var procMapsLine = regexp.MustCompile(`\A\S+ \S+ \S+ ([0-9a-f]+):([0-9a-f]+) (\d+) +(/.*?)( \(deleted\))?\z`)
+
+// cgroupPids lists the processes of a control group (of any hierarchy).
+// A vanished control group has no processes.
+func cgroupPids(controlGroup string) (pids []uint64, context string, err error) {
+	if controlGroup == "" {
+		return
+	}
+
+	for _, mount := range cgroupMounts {
+		procs := path.Join(mount, controlGroup, "cgroup.procs")
+		context = "cat " + procs
+
+		rawPids, errRF := ioutil.ReadFile(procs)
+		if errRF != nil {
+			if os.IsNotExist(errRF) {
+				continue
+			}
+
+			return nil, context, errRF
+		}
+
+		for _, line := range bytes.Split(rawPids, lineBreak) {
+			if pid, errPU := strconv.ParseUint(string(line), 10, 64); errPU == nil {
+				pids = append(pids, pid)
+			}
+		}
+
+		return
+	}
+
+	return
+}
+
+// procMappedFiles lists the files mapped into a process' memory.
+// A vanished process has no mapped files.
+func procMappedFiles(pid uint64) (files map[string]mappedFile, context string, err error) {
+	maps := "/proc/" + strconv.FormatUint(pid, 10) + "/maps"
+	context = "cat " + maps
+
+	rawMaps, errRF := ioutil.ReadFile(maps)
+	if errRF != nil {
+		if procVanished(errRF) {
+			errRF = nil
+		}
+
+		return nil, context, errRF
+	}
+
+	files = map[string]mappedFile{}
+
+	for _, line := range bytes.Split(rawMaps, lineBreak) {
+		if match := procMapsLine.FindSubmatch(line); match != nil {
+			major, _ := strconv.ParseUint(string(match[1]), 16, 64)
+			minor, _ := strconv.ParseUint(string(match[2]), 16, 64)
+			inode, _ := strconv.ParseUint(string(match[3]), 10, 64)
+
+			files[string(match[4])] = mappedFile{
+				dev:     mkdev(major, minor),
+				inode:   inode,
+				deleted: len(match[5]) > 0,
+			}
+		}
+	}
+
+	return
+}
+
+// fileReplaced tells whether a mapped file isn't the one on disk anymore
+// and when the one on disk (if any) has been modified.
+func fileReplaced(file string, mapped mappedFile) (replaced bool, mTime time.Time, err error) {
+	info, errStat := os.Stat(file)
+	if errStat != nil {
+		if os.IsNotExist(errStat) {
+			return true, time.Time{}, nil
+		}
+
+		return false, time.Time{}, errStat
+	}
+
+	mTime = info.ModTime()
+
+	if mapped.deleted {
+		replaced = true
+	} else if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		// A different device (e.g. overlayfs) doesn't allow to compare inodes.
+		replaced = uint64(stat.Dev) == mapped.dev && uint64(stat.Ino) != mapped.inode
+	}
+
+	return
+}
+
+// procExeReplaced tells whether a process' executable isn't the one on disk anymore
+// and when the one on disk (if any) has been put in place (its ctime, packages may preserve mtimes).
+// Vanished processes are considered not replaced.
+func procExeReplaced(pid uint64) (exe string, replaced bool, cTime time.Time, context string, err error) {
+	proc := "/proc/" + strconv.FormatUint(pid, 10)
+	link := proc + "/exe"
+	context = "readlink " + link
+
+	exe, err = os.Readlink(link)
+	if err != nil {
+		if procVanished(err) {
+			err = nil
+		}
+
+		return
+	}
+
+	// E.g. a package upgrade has replaced the executable, but the replacement's ctime is still of use.
+	deleted := strings.HasSuffix(exe, exeDeleted)
+	var running os.FileInfo
+
+	if deleted {
+		exe = strings.TrimSuffix(exe, exeDeleted)
+	} else {
+		context = "stat " + link
+
+		var errStat error
+		if running, errStat = os.Stat(link); errStat != nil {
+			if !procVanished(errStat) {
+				err = errStat
+			}
+
+			return
+		}
+	}
+
+	// The link is resolved relative to our root directory. So the executable of a process
+	// with its own mount namespace (e.g. RootImage=) exists only in the process' root directory
+	// and the one of a process chroot(2)ed into our mount namespace (RootDirectory=) only in ours.
+	for _, file := range [2]string{path.Join(proc, "root", exe), exe} {
+		context = FormatCmd("stat", []string{file}, nil)
+
+		onDisk, errStat := os.Stat(file)
+		if errStat != nil {
+			if os.IsNotExist(errStat) || deleted {
+				continue
+			}
+
+			return exe, false, time.Time{}, context, errStat
+		}
+
+		if !deleted && os.SameFile(running, onDisk) {
+			return exe, false, time.Time{}, context, nil
+		}
+
+		if stat, ok := onDisk.Sys().(*syscall.Stat_t); ok && cTime.IsZero() {
+			cTime = time.Unix(stat.Ctim.Unix())
+		}
+	}
+
+	return exe, true, cTime, context, nil
+}
+
+// procStartTime tells when a process has been started.
+// A vanished process has a zero start time.
+func procStartTime(pid uint64, bootTime time.Time) (start time.Time, context string, err error) {
+	stat := "/proc/" + strconv.FormatUint(pid, 10) + "/stat"
+	context = "cat " + stat
+
+	rawStat, errRF := ioutil.ReadFile(stat)
+	if errRF != nil {
+		if procVanished(errRF) {
+			errRF = nil
+		}
+
+		return time.Time{}, context, errRF
+	}
+
+	// The command (2nd field) may contain anything.
+	if end := bytes.LastIndexByte(rawStat, ')'); end >= 0 {
+		// The start time is the 22nd field.
+		if fields := bytes.Fields(rawStat[end+1:]); len(fields) > 19 {
+			if ticks, errPU := strconv.ParseUint(string(fields[19]), 10, 64); errPU == nil {
+				return bootTime.Add(time.Duration(ticks) * time.Second / userHz), context, nil
+			}
+		}
+	}
+
+	return time.Time{}, context, badProcStat
+}
+
+func procVanished(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == syscall.ESRCH
+	}
+
+	return false
+}
+
+// mkdev encodes a device number like glibc's makedev(3).
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfffff000)<<32 | (major&0xfff)<<8 | (minor&0xffffff00)<<12 | minor&0xff
+}
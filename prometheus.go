@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const promNamespace = "needrestart_"
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type promSample struct {
+	labels [][2]string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	help    string
+	samples []promSample
+}
+
+// promFamilies keeps the order of the metric families as added.
+type promFamilies struct {
+	families []*promFamily
+	index    map[string]*promFamily
+}
+
+// executePrometheusCheck prints metrics in the Prometheus text format or writes them to --textfile.
+// Unlike the other formats, the exit code only tells whether the metrics have been written.
+func executePrometheusCheck() int {
+	if textfile == "" && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(onTerminal())
+		return 3
+	}
+
+	result := runCheck()
+	families := assemblePrometheusMetrics(result, checkStatus(result))
+	metrics := []byte(families.String())
+
+	if textfile == "" {
+		if _, errWr := os.Stdout.Write(metrics); errWr != nil {
+			return 3
+		}
+
+		return 0
+	}
+
+	if errWFA := writeFileAtomically(textfile, metrics); errWFA != nil {
+		fmt.Printf("%s: %s\n", textfile, errWFA.Error())
+		return 3
+	}
+
+	return 0
+}
+
+func assemblePrometheusMetrics(result checkResult, status int) *promFamilies {
+	families := promFamilies{index: map[string]*promFamily{}}
+	var success float64 = 1
+
+	if result.errs != nil || result.failures != nil {
+		success = 0
+	}
+
+	families.add("status", "Check status (0 = OK, 1 = WARNING, 2 = CRITICAL, 3 = UNKNOWN).", float64(status))
+	families.add("scan_success", "Whether the scan succeeded without errors.", success)
+	families.add("scan_duration_seconds", "How long the scan took.", result.duration.Seconds())
+	families.add("errors", "Number of errors during the scan.", float64(len(result.errs)+len(result.failures)))
+
+	for _, service := range result.services {
+		families.add(
+			"service_stale_severity", "Severity of a stale service (0 = ok, 1 = warning, 2 = critical).",
+			float64(service.severity), [2]string{"service", service.name},
+		)
+
+		for _, packag := range service.packages {
+			if diff := packag.files[0].diff; diff >= 0 {
+				families.add(
+					"stale_seconds", "Newest modification of a package's files minus the start of a service using it.",
+					diff.Seconds(), [2]string{"service", service.name}, [2]string{"package", packag.name},
+				)
+			}
+		}
+	}
+
+	for _, service := range sortedServices(result.binariesReplaced) {
+		families.add(
+			"binary_replaced", "A service runs a binary which has been replaced.",
+			1, [2]string{"service", service}, [2]string{"binary", result.binariesReplaced[service]},
+		)
+	}
+
+	for _, perfdatum := range result.perfdata {
+		name := perfdatum.Label
+		value := perfdatum.Value
+		help := "Performance data " + name + " of the check."
+		var labels [][2]string
+
+		if colon := strings.LastIndexByte(name, ':'); colon >= 0 {
+			labels = append(labels, [2]string{"manager", name[:colon]})
+			name = "user_" + name[colon+1:]
+			help = "Performance data " + name[len("user_"):] + " of the check per user service manager."
+		}
+
+		if perfdatum.UOM == "us" {
+			name += "_seconds"
+			value *= float64(time.Microsecond) / float64(time.Second)
+		}
+
+		families.add(name, help, value, labels...)
+	}
+
+	return &families
+}
+
+func (f *promFamilies) add(name, help string, value float64, labels ...[2]string) {
+	family, hasFamily := f.index[name]
+	if !hasFamily {
+		family = &promFamily{name: promNamespace + name, help: help}
+		f.index[name] = family
+		f.families = append(f.families, family)
+	}
+
+	family.samples = append(family.samples, promSample{labels: labels, value: value})
+}
+
+func (f *promFamilies) String() string {
+	builder := strings.Builder{}
+
+	for _, family := range f.families {
+		builder.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		builder.WriteString("# TYPE " + family.name + " gauge\n")
+
+		for _, sample := range family.samples {
+			builder.WriteString(family.name)
+
+			if len(sample.labels) > 0 {
+				builder.WriteByte('{')
+
+				for i, label := range sample.labels {
+					if i > 0 {
+						builder.WriteByte(',')
+					}
+
+					builder.WriteString(label[0] + `="` + promLabelEscaper.Replace(label[1]) + `"`)
+				}
+
+				builder.WriteByte('}')
+			}
+
+			builder.WriteString(" " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+		}
+	}
+
+	return builder.String()
+}
+
+// writeFileAtomically writes a temporary file next to the target and renames it,
+// so readers (e.g. node_exporter) never see a partially written file.
+func writeFileAtomically(file string, content []byte) error {
+	tmp, errTF := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if errTF != nil {
+		return errTF
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, errWr := tmp.Write(content); errWr != nil {
+		tmp.Close()
+		return errWr
+	}
+
+	if errCh := tmp.Chmod(0644); errCh != nil {
+		tmp.Close()
+		return errCh
+	}
+
+	if errCl := tmp.Close(); errCl != nil {
+		return errCl
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+const failuresHeadline = "Some parts of the host couldn't be inspected:"
+const restartsHeadline = "Restarts of services which have not been restarted since some of their parts have been upgraded:"
+
+// Renderer turns a check result into plugin output (without performance data).
+type Renderer interface {
+	Render(result checkResult) string
+}
+
+const (
+	// verbositySummary shows only one line per stale service.
+	verbositySummary = iota
+	// verbosityPackages also shows the upgraded packages of each stale service.
+	verbosityPackages
+	// verbosityFiles also shows the files of each package modified after the service start.
+	verbosityFiles
+)
+
+const noStaleServices = "No service has not been restarted since some of its parts have been upgraded."
+
+// exeUninspectableNote summarizes the services whose executable couldn't be inspected due to missing privileges.
+func exeUninspectableNote(services uint64) string {
+	return "The executables of " + strconv.FormatUint(services, 10) +
+		" service(s) couldn't be inspected (requires root privileges or CAP_SYS_PTRACE)."
+}
+
+var renderers = map[string]Renderer{"html": htmlRenderer{}, "text": textRenderer{}}
+
+// staleFiles are the files of a package modified after the service start.
+func staleFiles(packag orderedPackage) []orderedFile {
+	// Files are ordered by diff descending.
+	end := sort.Search(len(packag.files), func(i int) bool {
+		return packag.files[i].diff < 0
+	})
+
+	return packag.files[:end]
+}
+
+// sortedFailures formats failures as "context: error" ordered by context.
+func sortedFailures(failures map[string]error) []string {
+	lines := make([]string, 0, len(failures))
+	for context, err := range failures {
+		lines = append(lines, context+": "+err.Error())
+	}
+
+	sort.Strings(lines)
+	return lines
+}
+
+func sortedServices(binariesReplaced map[string]string) []string {
+	services := make([]string, 0, len(binariesReplaced))
+	for service := range binariesReplaced {
+		services = append(services, service)
+	}
+
+	sort.Strings(services)
+	return services
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/Al2Klimov/go-monplug-utils"
+	"github.com/godbus/dbus/v5"
+	"strings"
+	"sync"
+)
+
+const (
+	// restartDone means the service has been restarted.
+	restartDone = "restarted"
+	// restartFailed means the service couldn't be restarted.
+	restartFailed = "failed"
+	// restartPlanned means the service would have been restarted without --dry-run.
+	restartPlanned = "would restart"
+	// restartDenied means the service (or one restarted along with it) matches --never-restart.
+	restartDenied = "never restarted automatically"
+	// restartReboot means the config file requests a reboot instead.
+	restartReboot = "reboot instead"
+)
+
+// restartedAlongDenied is why restarting a service is denied due to another one (the context).
+var restartedAlongDenied = errors.New("would be restarted along")
+
+// defaultNeverRestart are services restarting which would disrupt the host or remote access.
+var defaultNeverRestart = []string{
+	"systemd", "dbus", "dbus-broker", "getty@*", "serial-getty@*", "ssh", "sshd", `~\Auser@\d+\z`,
+}
+
+type restartAttempt struct {
+	service string
+	action  string
+	// parent is restarted, so the service is restarted along with it.
+	parent  string
+	context string
+	err     error
+}
+
+// restartLog collects the restart attempts as they're made, so they're not lost on --timeout.
+type restartLog struct {
+	sync.Mutex
+	attempts []restartAttempt
+}
+
+func (l *restartLog) add(attempt restartAttempt) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.attempts = append(l.attempts, attempt)
+}
+
+func (l *restartLog) list() []restartAttempt {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]restartAttempt(nil), l.attempts...)
+}
+
+// checkAndRestart runs the check and (unless --dry-run) restarts stale services and re-runs the check to verify.
+// It logs the restart attempts also to made.
+func checkAndRestart(conn *dbus.Conn, made *restartLog) checkResult {
+	result := inspectHost(conn)
+	if !restartStale || result.errs != nil {
+		return result
+	}
+
+	var candidates []string
+	var skipped []restartAttempt
+
+	for _, service := range result.services {
+		switch {
+		case service.severity == severityOK:
+		case neverRestart.match(service.name):
+			skipped = append(skipped, restartAttempt{service: service.name, action: restartDenied})
+		case service.policy.rebootInstead:
+			skipped = append(skipped, restartAttempt{service: service.name, action: restartReboot})
+		default:
+			candidates = append(candidates, service.name)
+		}
+	}
+
+	plan, alongWith, denied, failures := planRestarts(
+		candidates,
+		func(service string) (string, serviceDeps, error) {
+			return queryServiceDeps(conn, service)
+		},
+		neverRestart.match,
+	)
+
+	for _, service := range candidates {
+		if dependent, isDenied := denied[service]; isDenied {
+			skipped = append(skipped, restartAttempt{
+				service: service, action: restartDenied, context: dependent, err: restartedAlongDenied,
+			})
+		}
+	}
+
+	attempts := make([]restartAttempt, 0, len(candidates)+len(skipped))
+	rootFailed := map[string]bool{}
+	var restarted, failed uint64
+
+	restart := func(attempt *restartAttempt) {
+		if attempt.context, attempt.err = restartService(conn, attempt.service); attempt.err == nil {
+			attempt.action = restartDone
+			restarted++
+		} else {
+			attempt.action = restartFailed
+			failed++
+		}
+	}
+
+	for _, service := range plan {
+		attempt := restartAttempt{service: service, action: restartPlanned}
+
+		if !dryRun {
+			restart(&attempt)
+			rootFailed[service] = attempt.err != nil
+		}
+
+		attempts = append(attempts, attempt)
+		made.add(attempt)
+	}
+
+	for _, service := range candidates {
+		if parent, along := alongWith[service]; along {
+			attempt := restartAttempt{service: service, action: restartPlanned, parent: parent}
+
+			switch {
+			case dryRun:
+			case rootFailed[parent]:
+				// The service has likely not been restarted along with its parent.
+				attempt.parent = ""
+				restart(&attempt)
+			default:
+				attempt.action = restartDone
+				restarted++
+			}
+
+			attempts = append(attempts, attempt)
+			made.add(attempt)
+		}
+	}
+
+	// Even if the re-check fails, the restarts have happened.
+	if restarted+failed > 0 {
+		result = inspectHost(conn)
+	}
+
+	result.restarts = append(attempts, skipped...)
+	result.failures = collectErrs(result.failures, failures)
+
+	result.perfdata = append(result.perfdata, restartsPerfdata(restarted, failed)...)
+
+	return result
+}
+
+func restartsPerfdata(restarted, failed uint64) PerfdataCollection {
+	return PerfdataCollection{
+		Perfdata{
+			Label: "services_restarted",
+			Value: float64(restarted),
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+		},
+		Perfdata{
+			Label: "services_restart_failed",
+			Value: float64(failed),
+			Crit:  OptionalThreshold{IsSet: true, Inverted: true, Start: 1, End: posInf},
+			Min:   OptionalNumber{IsSet: true, Value: 0},
+		},
+	}
+}
+
+// planRestarts orders services to restart by After= and leaves out the ones (alongWith a parent)
+// restarted anyway on restarting others due to Requires=, BindsTo= or PartOf=.
+// Services restarting which would (transitively) restart a never restarted one are denied
+// along with the latter.
+// Failures to query dependencies are reported, but not fatal.
+func planRestarts(
+	candidates []string, queryDeps func(service string) (string, serviceDeps, error),
+	neverRestart func(service string) bool,
+) (plan []string, alongWith map[string]string, denied map[string]string, failures map[string]error) {
+	allDeps := map[string]serviceDeps{}
+
+	depsOf := func(service string) serviceDeps {
+		deps, known := allDeps[service]
+		if !known {
+			var context string
+			var errQSD error
+
+			if context, deps, errQSD = queryDeps(service); errQSD != nil {
+				failures = collectErrs(failures, map[string]error{context: errQSD})
+			}
+
+			allDeps[service] = deps
+		}
+
+		return deps
+	}
+
+	var allowed []string
+
+	for _, service := range candidates {
+		if dependent := restartsDenied(service, depsOf, neverRestart); dependent != "" {
+			if denied == nil {
+				denied = map[string]string{}
+			}
+
+			denied[service] = dependent
+		} else {
+			allowed = append(allowed, service)
+		}
+	}
+
+	candidates = allowed
+
+	order := make(map[string]int, len(candidates))
+	for i, service := range candidates {
+		order[service] = i
+	}
+
+	// restartedBy are the candidates restarting which (transitively) restarts a candidate, too.
+	restartedBy := make(map[string]map[string]struct{}, len(candidates))
+
+	for _, service := range candidates {
+		by := map[string]struct{}{}
+		seen := map[string]struct{}{service: {}}
+		queue := []string{service}
+
+		for len(queue) > 0 {
+			unit := queue[0]
+			queue = queue[1:]
+
+			for _, parent := range depsOf(unit).restartedWith {
+				if _, hasSeen := seen[parent]; !hasSeen {
+					seen[parent] = struct{}{}
+					queue = append(queue, parent)
+
+					if _, isCandidate := order[parent]; isCandidate {
+						by[parent] = struct{}{}
+					}
+				}
+			}
+		}
+
+		restartedBy[service] = by
+	}
+
+	// Of services restarting each other, the first one is restarted.
+	alongAnyway := func(service string) bool {
+		for parent := range restartedBy[service] {
+			if _, mutual := restartedBy[parent][service]; !mutual || order[parent] < order[service] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	alongWith = map[string]string{}
+	toRestart := map[string]struct{}{}
+
+	for _, service := range candidates {
+		if alongAnyway(service) {
+			for _, parent := range candidates {
+				if _, isParent := restartedBy[service][parent]; isParent && !alongAnyway(parent) {
+					alongWith[service] = parent
+					break
+				}
+			}
+		}
+
+		if _, along := alongWith[service]; !along {
+			toRestart[service] = struct{}{}
+		}
+	}
+
+	// Restart the services in candidates' order unless they're After= ones not restarted yet.
+	// On cycles just take the first remaining one.
+	done := map[string]struct{}{}
+
+	for len(plan) < len(toRestart) {
+		next := ""
+
+		for _, service := range candidates {
+			if restartPending(service, toRestart, done) && restartsPlanned(depsOf(service).after, toRestart, done) {
+				next = service
+				break
+			}
+		}
+
+		if next == "" {
+			for _, service := range candidates {
+				if restartPending(service, toRestart, done) {
+					next = service
+					break
+				}
+			}
+		}
+
+		plan = append(plan, next)
+		done[next] = struct{}{}
+	}
+
+	return
+}
+
+// restartsDenied finds a never restarted service restarted along with a service (if any).
+func restartsDenied(service string, depsOf func(service string) serviceDeps, neverRestart func(service string) bool) string {
+	seen := map[string]struct{}{service: {}}
+	queue := []string{service}
+
+	for len(queue) > 0 {
+		unit := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range depsOf(unit).restarts {
+			if _, hasSeen := seen[dependent]; !hasSeen {
+				if neverRestart(dependent) {
+					return dependent
+				}
+
+				seen[dependent] = struct{}{}
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	return ""
+}
+
+// restartPending tells whether a service is to be restarted, but hasn't been planned yet.
+func restartPending(service string, toRestart, done map[string]struct{}) bool {
+	_, restart := toRestart[service]
+	_, isDone := done[service]
+	return restart && !isDone
+}
+
+// restartsPlanned tells whether all services to be restarted before a service have been planned.
+func restartsPlanned(after []string, toRestart, done map[string]struct{}) bool {
+	for _, service := range after {
+		if restartPending(service, toRestart, done) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serviceDeps are the services a service depends on in terms of restarting.
+type serviceDeps struct {
+	// restartedWith restart this service, too (Requires=, BindsTo=, PartOf=).
+	restartedWith []string
+	// restarts are restarted along with this service (RequiredBy=, BoundBy=, ConsistsOf=).
+	restarts []string
+	// after shall be restarted before this service.
+	after []string
+}
+
+// newServiceDeps picks the services out of dependency properties' units.
+func newServiceDeps(uid string, properties map[string][]string) serviceDeps {
+	var deps serviceDeps
+
+	for _, property := range [3]string{"Requires", "BindsTo", "PartOf"} {
+		deps.restartedWith = append(deps.restartedWith, unitsServices(uid, properties[property])...)
+	}
+
+	for _, property := range [3]string{"RequiredBy", "BoundBy", "ConsistsOf"} {
+		deps.restarts = append(deps.restarts, unitsServices(uid, properties[property])...)
+	}
+
+	deps.after = unitsServices(uid, properties["After"])
+	return deps
+}
+
+func unitsServices(uid string, units []string) (services []string) {
+	for _, unit := range units {
+		if match := serviceUnit.FindStringSubmatch(unit); match != nil {
+			services = append(services, userServiceName(uid, match[1]))
+		}
+	}
+
+	return
+}
+
+// queryServiceDeps queries a service's dependencies like restartService restarts it.
+func queryServiceDeps(conn *dbus.Conn, service string) (string, serviceDeps, error) {
+	if match := userService.FindStringSubmatch(service); match != nil {
+		return showServiceDeps(match[1][len("user@"):], service[len(match[0]):])
+	}
+
+	if conn != nil {
+		return dbusShowServiceDeps(conn, service)
+	}
+
+	return showServiceDeps("", service)
+}
+
+// restartService restarts a service and waits for it like systemctl restart.
+// System services are restarted via conn unless it's nil, like showServices queries them.
+func restartService(conn *dbus.Conn, service string) (context string, err error) {
+	if match := userService.FindStringSubmatch(service); match != nil {
+		return systemctlRestart(match[1][len("user@"):], service[len(match[0]):])
+	}
+
+	if conn != nil {
+		return dbusRestartService(conn, service)
+	}
+
+	return systemctlRestart("", service)
+}
+
+func systemctlRestart(uid, service string) (string, error) {
+	cmd, _, errSR := system(
+		"systemctl", append(systemctlManagerArgs(uid), "restart", service+".service"),
+		map[string]string{"LC_ALL": "C"}, "/",
+	)
+	return cmd, errSR
+}
+
+// dbusRestartService does the same as systemctlRestart("", service), but via D-Bus.
+func dbusRestartService(conn *dbus.Conn, service string) (string, error) {
+	context := "dbus " + dbusSystemd + ".Manager.RestartUnit " + service + ".service"
+
+	ctx, end, errBO := beginOperation(context)
+	defer end()
+
+	if errBO != nil {
+		return context, errBO
+	}
+
+	manager := conn.Object(dbusSystemd, "/org/freedesktop/systemd1")
+	chSignal := make(chan *dbus.Signal, 64)
+
+	conn.Signal(chSignal)
+	defer conn.RemoveSignal(chSignal)
+
+	errAMS := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath("/org/freedesktop/systemd1"),
+		dbus.WithMatchInterface(dbusSystemd+".Manager"),
+		dbus.WithMatchMember("JobRemoved"),
+	)
+	if errAMS != nil {
+		return context, errAMS
+	}
+
+	// Otherwise systemd doesn't emit JobRemoved. Subscribing again is harmless.
+	manager.CallWithContext(ctx, dbusSystemd+".Manager.Subscribe", 0)
+
+	var job dbus.ObjectPath
+	if errRU := manager.CallWithContext(ctx, dbusSystemd+".Manager.RestartUnit", 0, service+".service", "replace").Store(&job); errRU != nil {
+		return context, errRU
+	}
+
+	for {
+		select {
+		case signal := <-chSignal:
+			// JobRemoved(u id, o job, s unit, s result)
+			if signal.Name == dbusSystemd+".Manager.JobRemoved" && len(signal.Body) == 4 {
+				if removed, _ := signal.Body[1].(dbus.ObjectPath); removed == job {
+					if result, _ := signal.Body[3].(string); result != "done" {
+						return context, fmt.Errorf("job %s", result)
+					}
+
+					return context, nil
+				}
+			}
+		case <-ctx.Done():
+			return context, ctx.Err()
+		}
+	}
+}
+
+// formatRestartAttempt describes an attempt like "restarted", "restarted along with foo" or "failed (ctx: err)".
+func formatRestartAttempt(attempt restartAttempt) string {
+	if attempt.parent != "" {
+		return attempt.action + " along with " + attempt.parent
+	}
+
+	if attempt.err == nil {
+		return attempt.action
+	}
+
+	return attempt.action + " (" + strings.TrimSpace(attempt.context+": "+attempt.err.Error()) + ")"
+}
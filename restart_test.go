@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRestarts(t *testing.T) {
+	errQuery := errors.New("no such unit")
+
+	for _, tc := range []struct {
+		name       string
+		candidates []string
+		deps       map[string]serviceDeps
+		plan       []string
+		alongWith  map[string]string
+		denied     map[string]string
+		failures   map[string]error
+	}{
+		{
+			name:       "independent",
+			candidates: []string{"a", "b"},
+			plan:       []string{"a", "b"},
+			alongWith:  map[string]string{},
+		},
+		{
+			name:       "after",
+			candidates: []string{"a", "b", "c"},
+			deps:       map[string]serviceDeps{"a": {after: []string{"c"}}, "c": {after: []string{"b"}}},
+			plan:       []string{"b", "c", "a"},
+			alongWith:  map[string]string{},
+		},
+		{
+			name:       "after cycle",
+			candidates: []string{"a", "b"},
+			deps:       map[string]serviceDeps{"a": {after: []string{"b"}}, "b": {after: []string{"a"}}},
+			plan:       []string{"a", "b"},
+			alongWith:  map[string]string{},
+		},
+		{
+			name:       "after non-candidate",
+			candidates: []string{"a", "b"},
+			deps:       map[string]serviceDeps{"a": {after: []string{"x"}}},
+			plan:       []string{"a", "b"},
+			alongWith:  map[string]string{},
+		},
+		{
+			name:       "restarted along",
+			candidates: []string{"php-fpm", "nginx"},
+			deps:       map[string]serviceDeps{"php-fpm": {restartedWith: []string{"nginx"}}},
+			plan:       []string{"nginx"},
+			alongWith:  map[string]string{"php-fpm": "nginx"},
+		},
+		{
+			name:       "restarted along transitively",
+			candidates: []string{"a", "c"},
+			deps: map[string]serviceDeps{
+				"a": {restartedWith: []string{"b"}},
+				"b": {restartedWith: []string{"c"}},
+			},
+			plan:      []string{"c"},
+			alongWith: map[string]string{"a": "c"},
+		},
+		{
+			name:       "restarted along the root",
+			candidates: []string{"a", "b", "c"},
+			deps: map[string]serviceDeps{
+				"a": {restartedWith: []string{"b"}},
+				"b": {restartedWith: []string{"c"}},
+			},
+			plan:      []string{"c"},
+			alongWith: map[string]string{"a": "c", "b": "c"},
+		},
+		{
+			name:       "restarting each other",
+			candidates: []string{"a", "b"},
+			deps: map[string]serviceDeps{
+				"a": {restartedWith: []string{"b"}},
+				"b": {restartedWith: []string{"a"}},
+			},
+			plan:      []string{"a"},
+			alongWith: map[string]string{"b": "a"},
+		},
+		{
+			name:       "restarting a never restarted one",
+			candidates: []string{"a", "b", "c"},
+			deps: map[string]serviceDeps{
+				"a": {restarts: []string{"ssh"}},
+				"b": {restarts: []string{"x"}},
+				"x": {restarts: []string{"sshd"}},
+			},
+			plan:      []string{"c"},
+			alongWith: map[string]string{},
+			denied:    map[string]string{"a": "ssh", "b": "sshd"},
+		},
+		{
+			name:       "restarted along a denied one",
+			candidates: []string{"a", "b"},
+			deps: map[string]serviceDeps{
+				"a": {restarts: []string{"ssh"}},
+				"b": {restartedWith: []string{"a"}},
+			},
+			plan:      []string{"b"},
+			alongWith: map[string]string{},
+			denied:    map[string]string{"a": "ssh"},
+		},
+		{
+			name:       "restarting each other via dependents",
+			candidates: []string{"a", "b"},
+			deps: map[string]serviceDeps{
+				"a": {restarts: []string{"b"}},
+				"b": {restarts: []string{"a"}},
+			},
+			plan:      []string{"a", "b"},
+			alongWith: map[string]string{},
+		},
+		{
+			name:       "query failure",
+			candidates: []string{"a", "missing"},
+			deps:       map[string]serviceDeps{"a": {after: []string{"missing"}}},
+			plan:       []string{"missing", "a"},
+			alongWith:  map[string]string{},
+			failures:   map[string]error{"missing": errQuery},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, alongWith, denied, failures := planRestarts(
+				tc.candidates,
+				func(service string) (string, serviceDeps, error) {
+					if service == "missing" {
+						return service, serviceDeps{}, errQuery
+					}
+
+					return service, tc.deps[service], nil
+				},
+				func(service string) bool {
+					return service == "ssh" || service == "sshd"
+				},
+			)
+
+			if !reflect.DeepEqual(plan, tc.plan) {
+				t.Errorf("expected plan %v, got %v", tc.plan, plan)
+			}
+
+			if !reflect.DeepEqual(alongWith, tc.alongWith) {
+				t.Errorf("expected along with %v, got %v", tc.alongWith, alongWith)
+			}
+
+			if !reflect.DeepEqual(denied, tc.denied) {
+				t.Errorf("expected denied %v, got %v", tc.denied, denied)
+			}
+
+			if !reflect.DeepEqual(failures, tc.failures) {
+				t.Errorf("expected failures %v, got %v", tc.failures, failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+const rpmFileConfig = 1 << 0
+const rpmFileGhost = 1 << 6
+
+var rpmProperty = regexp.MustCompile(`\A([^=]+)=(.*)\z`)
+var rpmFile = regexp.MustCompile(`\A(\d+) (/.*)\z`)
+
+type rpmBackend struct{}
+
+func init() {
+	registerPackageBackend("rpm", 40, rpmBackend{})
+}
+
+func (rpmBackend) Detect() bool {
+	_, errLP := exec.LookPath("rpm")
+	return errLP == nil
+}
+
+func (rpmBackend) DatabaseFiles() []string {
+	return []string{
+		"/var/lib/rpm/Packages", "/var/lib/rpm/rpmdb.sqlite",
+		"/usr/lib/sysimage/rpm/Packages.db", "/usr/lib/sysimage/rpm/rpmdb.sqlite",
+	}
+}
+
+func (rpmBackend) ShowPackages() (packagesInfo, map[string]error) {
+	return rpmShowPackages()
+}
+
+func rpmShowPackages() (packagesInfo, map[string]error) {
+	cmd, rawPackages, errRQ := system(
+		"rpm",
+		[]string{
+			"-qa",
+			"--qf", `Package=%{NAME}-%{VERSION}-%{RELEASE}.%{ARCH}
+[Requires=%{REQUIRENAME}
+][Provides=%{PROVIDENAME}
+][Obsoletes=%{OBSOLETENAME}
+][File=%{FILEFLAGS} %{FILENAMES}
+]`,
+		},
+		map[string]string{"LC_ALL": "C"},
+		"/",
+	)
+	if errRQ != nil {
+		return packagesInfo{}, map[string]error{cmd: errRQ}
+	}
+
+	return rpmParsePackages(rawPackages), nil
+}
+
+// rpmParsePackages parses the output of rpmShowPackages' query.
+func rpmParsePackages(rawPackages []byte) packagesInfo {
+	packageMetaData := map[string]packageInfo{}
+	nonConfFiles := map[string]string{}
+	allFiles := map[string]string{}
+	packag := ""
+	var pkgInfo packageInfo
+
+	for _, line := range bytes.Split(rawPackages, lineBreak) {
+		if match := rpmProperty.FindSubmatch(line); match != nil {
+			value := string(match[2])
+
+			if value == "(none)" {
+				continue
+			}
+
+			switch string(match[1]) {
+			case "Package":
+				packag = value
+				pkgInfo = packageInfo{
+					deps:         map[string]struct{}{},
+					aliases:      map[string]struct{}{},
+					nonConfFiles: map[string]struct{}{},
+				}
+
+				packageMetaData[packag] = pkgInfo
+			case "Requires":
+				if packag != "" {
+					pkgInfo.deps[value] = struct{}{}
+				}
+			case "Provides", "Obsoletes":
+				if packag != "" {
+					pkgInfo.aliases[value] = struct{}{}
+				}
+			case "File":
+				if packag != "" {
+					if file := rpmFile.FindStringSubmatch(value); file != nil {
+						allFiles[file[2]] = packag
+
+						if flags, errPU := strconv.ParseUint(file[1], 10, 64); errPU == nil &&
+							flags&(rpmFileConfig|rpmFileGhost) == 0 {
+							pkgInfo.nonConfFiles[file[2]] = struct{}{}
+							nonConfFiles[file[2]] = packag
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// RPM allows to depend on files (e.g. /bin/sh) which are not necessarily provided explicitly.
+	for _, pkgInfo := range packageMetaData {
+		for dep := range pkgInfo.deps {
+			if len(dep) > 0 && dep[0] == '/' {
+				if owner, hasOwner := allFiles[dep]; hasOwner {
+					delete(pkgInfo.deps, dep)
+					pkgInfo.deps[owner] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return packagesInfo{packages: packageMetaData, nonConfFiles: nonConfFiles}
+}
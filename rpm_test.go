@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRpmParsePackages(t *testing.T) {
+	packages := rpmParsePackages([]byte(`Package=bash-5.1.8-6.el9.x86_64
+Requires=/bin/sh
+Requires=libc.so.6()(64bit)
+Provides=/bin/bash
+Provides=bash(x86-64)
+File=0 /usr/bin/bash
+File=1 /etc/skel/.bashrc
+File=64 /var/log/bash.log
+Package=glibc-2.34-60.el9.x86_64
+Requires=(none)
+Provides=libc.so.6()(64bit)
+Obsoletes=glibc-profile
+File=0 /usr/lib64/libc.so.6
+Package=filesystem-3.16-2.el9.x86_64
+File=0 /usr
+File=0 /bin/sh
+`))
+
+	bash := packages.packages["bash-5.1.8-6.el9.x86_64"]
+
+	// The file dependency is replaced by its owner.
+	if expected := set("filesystem-3.16-2.el9.x86_64", "libc.so.6()(64bit)"); !reflect.DeepEqual(bash.deps, expected) {
+		t.Errorf("deps of bash: expected %v, got %v", expected, bash.deps)
+	}
+
+	if expected := set("/bin/bash", "bash(x86-64)"); !reflect.DeepEqual(bash.aliases, expected) {
+		t.Errorf("aliases of bash: expected %v, got %v", expected, bash.aliases)
+	}
+
+	// Config files and ghosts are left out.
+	if expected := set("/usr/bin/bash"); !reflect.DeepEqual(bash.nonConfFiles, expected) {
+		t.Errorf("files of bash: expected %v, got %v", expected, bash.nonConfFiles)
+	}
+
+	glibc := packages.packages["glibc-2.34-60.el9.x86_64"]
+
+	if len(glibc.deps) > 0 {
+		t.Errorf("deps of glibc: expected none, got %v", glibc.deps)
+	}
+
+	if expected := set("libc.so.6()(64bit)", "glibc-profile"); !reflect.DeepEqual(glibc.aliases, expected) {
+		t.Errorf("aliases of glibc: expected %v, got %v", expected, glibc.aliases)
+	}
+
+	if owner := packages.nonConfFiles["/usr/lib64/libc.so.6"]; owner != "glibc-2.34-60.el9.x86_64" {
+		t.Errorf("owner of /usr/lib64/libc.so.6: expected glibc, got %q", owner)
+	}
+
+	if len(packages.packages) != 3 {
+		t.Errorf("expected 3 packages, got %d", len(packages.packages))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const serveIndex = `<html><head><title>check_systemd_needrestart</title></head><body>
+<p><a href="metrics">metrics</a> (Prometheus text format)</p>
+<p><a href="status.json">status.json</a> (like --format json)</p>
+</body></html>
+`
+
+// serveState holds the latest scan's responses, so requests don't trigger scans.
+type serveState struct {
+	sync.RWMutex
+	metrics []byte
+	status  []byte
+}
+
+// serve rescans periodically in the background and serves the results via HTTP until SIGINT or SIGTERM.
+func serve() int {
+	listener, errLs := listenOn(listen)
+	if errLs != nil {
+		log.Print(errLs)
+		return 3
+	}
+
+	state := &serveState{}
+	mux := http.NewServeMux()
+	server := &http.Server{Handler: mux, ReadTimeout: time.Minute, WriteTimeout: time.Minute}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(serveIndex))
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		state.respond(w, &state.metrics, "text/plain; version=0.0.4; charset=utf-8")
+	})
+
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		state.respond(w, &state.status, "application/json")
+	})
+
+	go state.scanPeriodically()
+
+	chSignal := make(chan os.Signal, 1)
+	signal.Notify(chSignal, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		log.Printf("Received %s, shutting down", <-chSignal)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("Listening on %s", listen)
+
+	if errSv := server.Serve(listener); errSv != http.ErrServerClosed {
+		log.Print(errSv)
+		return 3
+	}
+
+	return 0
+}
+
+// listenOn listens on a TCP address or, if prefixed with "unix:", on a Unix socket.
+func listenOn(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		socket := address[len("unix:"):]
+
+		// Remove a stale socket left behind by a killed predecessor, but nothing else.
+		if info, errLs := os.Lstat(socket); errLs == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(socket)
+		}
+
+		return net.Listen("unix", socket)
+	}
+
+	return net.Listen("tcp", address)
+}
+
+func (s *serveState) scanPeriodically() {
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+
+	for {
+		s.scan()
+		<-ticker.C
+	}
+}
+
+func (s *serveState) scan() {
+	result := runCheck()
+	status := checkStatus(result)
+
+	for _, errs := range [2]map[string]error{result.errs, result.failures} {
+		for ctx, err := range errs {
+			log.Printf("%s: %s", ctx, err.Error())
+		}
+	}
+
+	families := assemblePrometheusMetrics(result, status)
+	families.add("last_scan_timestamp_seconds", "When the last scan finished.", float64(time.Now().UnixNano())/1e9)
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+
+	if errEnc := encoder.Encode(assembleJSONOutput(result, status)); errEnc != nil {
+		log.Print(errEnc)
+		return
+	}
+
+	s.Lock()
+	s.metrics = []byte(families.String())
+	s.status = buf.Bytes()
+	s.Unlock()
+
+	log.Printf("Scan finished after %s with status %s", result.duration, statusNames[status])
+}
+
+func (s *serveState) respond(w http.ResponseWriter, body *[]byte, contentType string) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if *body == nil {
+		http.Error(w, "The first scan is still in progress.", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(*body)
+}
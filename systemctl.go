@@ -2,21 +2,35 @@ package main
 
 import (
 	"bytes"
-	. "github.com/Al2Klimov/go-exec-utils"
+	"fmt"
 	linux "github.com/Al2Klimov/go-linux-apis"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type serviceInfo struct {
-	activeSince time.Time
-	anyFile     string
+	activeSince  time.Time
+	anyFile      string
+	execPaths    []string
+	controlGroup string
+	exeReplaced  string
+	// exeChanged is when the replacement of exeReplaced (if any) has been put in place.
+	exeChanged time.Time
+	// exeUninspectable means the main process' executable couldn't be inspected due to missing privileges.
+	exeUninspectable bool
+	oldestPid        uint64
 }
 
 type servicesInfo struct {
 	services      map[string]serviceInfo
 	servicesTotal uint64
-	errs          map[string]error
+	// errs are fatal if services is nil, otherwise they concern single services.
+	errs map[string]error
 }
 
 type systemdInfo struct {
@@ -34,50 +48,113 @@ type systemctlShowResult struct {
 	cmd          string
 	activeSince  time.Time
 	fragmentPath string
-	err          error
+	execPaths    []string
+	controlGroup string
+	mainPid      uint64
+	exeReplaced  string
+	exeChanged   time.Time
+	// exeUninspectable is like serviceInfo's.
+	exeUninspectable bool
+	oldestPid        uint64
+	// failures prevented inspecting some aspects of the service, but not the others.
+	failures map[string]error
+	err      error
 }
 
 var serviceUnit = regexp.MustCompile(`\A(.+)\.service\z`)
+var userManager = regexp.MustCompile(`\Auser@(\d+)\z`)
+var userService = regexp.MustCompile(`\A(user@\d+):`)
 var serviceProperty = regexp.MustCompile(`\A([^=]+)=(.*)\z`)
+var execPath = regexp.MustCompile(`\bpath=(\S+)`)
 
-func showServices(ch chan<- servicesInfo) {
-	cmd, unitFiles, errLUF := System("systemctl", []string{"list-units"}, map[string]string{"LC_ALL": "C"}, "/")
-	if errLUF != nil {
-		ch <- servicesInfo{errs: map[string]error{cmd: errLUF}}
-		return
+var execProperties = map[string]struct{}{
+	"ExecStart": {}, "ExecStartPre": {}, "ExecStartPost": {}, "ExecReload": {},
+}
+
+// showServices queries the system services via conn or (if nil) systemctl.
+func showServices(conn *dbus.Conn, ch chan<- servicesInfo) {
+	var units []string
+	var show func(service string, ch chan<- systemctlShowResult)
+
+	if conn != nil {
+		paths, errLU := dbusListServices(conn)
+		if errLU != nil {
+			ch <- servicesInfo{errs: map[string]error{"dbus " + dbusSystemd + ".Manager.ListUnits": errLU}}
+			return
+		}
+
+		for unit := range paths {
+			units = append(units, unit)
+		}
+
+		show = func(service string, ch chan<- systemctlShowResult) {
+			dbusShowService(conn, service, paths[service], ch)
+		}
+	} else {
+		cmd, systemUnits, errLS := listServices("")
+		if errLS != nil {
+			ch <- servicesInfo{errs: map[string]error{cmd: errLS}}
+			return
+		}
+
+		units = systemUnits
+
+		show = func(service string, ch chan<- systemctlShowResult) {
+			showService("", service, ch)
+		}
 	}
 
 	chSystemdInfo := make(chan systemdInfo, 1)
 	chSystemctlShow := make(chan systemctlShowResult, 64)
 	var servicesTotal uint64 = 0
+	errSSS := map[string]error{}
 
 	go getSystemdInfo(chSystemdInfo)
 
-	for _, line := range bytes.Split(unitFiles, lineBreak)[1:] {
-		line = bytes.Trim(line, " \t\r\n")
+	for _, unit := range units {
+		go show(unit, chSystemctlShow)
+		servicesTotal++
+	}
 
-		if len(line) < 1 {
-			break
-		}
+	if userServices {
+		for _, unit := range units {
+			if match := userManager.FindStringSubmatch(unit); match != nil {
+				cmd, userUnits, errLS := listServices(match[1])
+				if errLS != nil {
+					errSSS[cmd] = errLS
+					continue
+				}
 
-		if match1 := firstWord.FindSubmatch(line); match1 != nil {
-			if match2 := serviceUnit.FindSubmatch(match1[1]); match2 != nil {
-				go showService(string(match2[1]), chSystemctlShow)
-				servicesTotal++
+				for _, userUnit := range userUnits {
+					go showService(match[1], userUnit, chSystemctlShow)
+					servicesTotal++
+				}
 			}
 		}
 	}
 
-	unitFiles = nil
+	units = nil
 
 	var result systemctlShowResult
 	services := map[string]serviceInfo{}
-	errSSS := map[string]error{}
 
 	for pending := servicesTotal; pending > 0; pending-- {
 		if result = <-chSystemctlShow; result.err == nil {
 			if result.activeSince != (time.Time{}) {
-				services[result.service] = serviceInfo{activeSince: result.activeSince, anyFile: result.fragmentPath}
+				services[result.service] = serviceInfo{
+					activeSince:      result.activeSince,
+					anyFile:          result.fragmentPath,
+					execPaths:        result.execPaths,
+					controlGroup:     result.controlGroup,
+					exeReplaced:      result.exeReplaced,
+					exeChanged:       result.exeChanged,
+					exeUninspectable: result.exeUninspectable,
+					oldestPid:        result.oldestPid,
+				}
+			}
+
+			for c, e := range result.failures {
+				errSSS[c] = e
 			}
 		} else {
 			errSSS[result.cmd] = result.err
@@ -86,43 +163,114 @@ func showServices(ch chan<- servicesInfo) {
 
 	close(chSystemctlShow)
 
-	if syIn := <-chSystemdInfo; syIn.errs == nil {
+	syIn := <-chSystemdInfo
+	if syIn.activeSince != (time.Time{}) {
 		services["systemd"] = syIn.serviceInfo
-	} else {
-		for c, e := range syIn.errs {
-			errSSS[c] = e
+	}
+
+	for c, e := range syIn.errs {
+		errSSS[c] = e
+	}
+
+	if len(errSSS) < 1 {
+		errSSS = nil
+	}
+
+	ch <- servicesInfo{services: services, servicesTotal: servicesTotal, errs: errSSS}
+}
+
+// listServices lists the services of the system manager or (if uid isn't empty) a user's manager.
+func listServices(uid string) (cmd string, services []string, err error) {
+	cmd, unitFiles, errLUF := system(
+		"systemctl", append(systemctlManagerArgs(uid), "list-units"), map[string]string{"LC_ALL": "C"}, "/",
+	)
+	if errLUF != nil {
+		return cmd, nil, errLUF
+	}
+
+	for _, line := range bytes.Split(unitFiles, lineBreak)[1:] {
+		line = bytes.Trim(line, " \t\r\n")
+
+		if len(line) < 1 {
+			break
+		}
+
+		if match1 := firstWord.FindSubmatch(line); match1 != nil {
+			if match2 := serviceUnit.FindSubmatch(match1[1]); match2 != nil {
+				services = append(services, string(match2[1]))
+			}
 		}
 	}
 
-	if len(errSSS) > 0 {
-		ch <- servicesInfo{errs: errSSS}
-		return
+	return
+}
+
+func systemctlManagerArgs(uid string) []string {
+	if uid == "" {
+		return nil
 	}
 
-	ch <- servicesInfo{services: services, servicesTotal: servicesTotal, errs: nil}
+	return []string{"--user", "-M", uid + "@"}
+}
+
+// userServiceName labels a user's service like user@1000:syncthing.
+func userServiceName(uid, service string) string {
+	if uid == "" {
+		return service
+	}
+
+	return "user@" + uid + ":" + service
 }
 
 func getSystemdInfo(ch chan<- systemdInfo) {
-	if uptime, errGUT := linux.GetUptime(); errGUT == nil {
-		ch <- systemdInfo{
-			serviceInfo: serviceInfo{activeSince: time.Now().Add(-uptime.UpTime), anyFile: "/sbin/init"},
-			errs:        nil,
-		}
-	} else {
+	uptime, errGUT := linux.GetUptime()
+	if errGUT != nil {
 		ch <- systemdInfo{errs: map[string]error{"cat /proc/uptime": errGUT}}
+		return
+	}
+
+	var errs map[string]error
+
+	// systemd can still be judged by its start time.
+	exe, replaced, cTime, context, errPER := procExeReplaced(1)
+	if errPER != nil && !os.IsPermission(errPER) {
+		errs = map[string]error{context: errPER}
+	}
+
+	if !replaced {
+		exe = ""
+	}
+
+	ch <- systemdInfo{
+		serviceInfo: serviceInfo{
+			activeSince:      time.Now().Add(-uptime.UpTime),
+			anyFile:          "/sbin/init",
+			controlGroup:     "/init.scope",
+			exeReplaced:      exe,
+			exeChanged:       cTime,
+			exeUninspectable: os.IsPermission(errPER),
+		},
+		errs: errs,
 	}
 }
 
-func showService(service string, ch chan<- systemctlShowResult) {
-	cmd, rawProperties, errSSS := System(
-		"systemctl", []string{
+func showService(uid, service string, ch chan<- systemctlShowResult) {
+	cmd, rawProperties, errSSS := system(
+		"systemctl", append(
+			systemctlManagerArgs(uid),
 			"show",
 			"-p", "ActiveState",
 			"-p", "SubState",
-			"-p", "ExecMainStartTimestamp",
+			"-p", "ExecMainStartTimestampMonotonic",
 			"-p", "FragmentPath",
-			service + ".service",
-		},
+			"-p", "ControlGroup",
+			"-p", "ExecMainPID",
+			"-p", "ExecStart",
+			"-p", "ExecStartPre",
+			"-p", "ExecStartPost",
+			"-p", "ExecReload",
+			service+".service",
+		),
 		map[string]string{"LC_ALL": "C"},
 		"/",
 	)
@@ -131,29 +279,163 @@ func showService(service string, ch chan<- systemctlShowResult) {
 		return
 	}
 
-	properties := make(map[string]string, 3)
+	properties := make(map[string]string, 6)
+	var execPaths []string
 
 	for _, line := range bytes.Split(rawProperties, lineBreak) {
 		if match := serviceProperty.FindSubmatch(line); match != nil {
-			properties[string(match[1])] = string(match[2])
+			property := string(match[1])
+
+			// Multiple commands may be given in multiple lines.
+			if _, isExec := execProperties[property]; isExec {
+				for _, path := range execPath.FindAllSubmatch(match[2], -1) {
+					execPaths = append(execPaths, string(path[1]))
+				}
+			} else {
+				properties[property] = string(match[2])
+			}
 		}
 	}
 
 	var activeSince time.Time
+	var mainPid uint64 = 0
+
 	if properties["ActiveState"] == "active" && properties["SubState"] == "running" {
-		var errTP error
-		activeSince, errTP = time.Parse("Mon 2006-01-02 15:04:05 MST", properties["ExecMainStartTimestamp"])
+		rawSince := properties["ExecMainStartTimestampMonotonic"]
+
+		usec, errPU := strconv.ParseUint(rawSince, 10, 64)
+		if errPU != nil || usec == 0 {
+			ch <- systemctlShowResult{cmd: cmd, err: fmt.Errorf("invalid ExecMainStartTimestampMonotonic: %q", rawSince)}
+			return
+		}
 
-		if errTP != nil {
-			activeSince = time.Time{}
+		var errMTT error
+		if activeSince, errMTT = monotonicToTime(usec); errMTT != nil {
+			ch <- systemctlShowResult{cmd: "clock_gettime CLOCK_MONOTONIC", err: errMTT}
+			return
 		}
+
+		mainPid, _ = strconv.ParseUint(properties["ExecMainPID"], 10, 64)
 	}
 
-	ch <- systemctlShowResult{
-		service:      service,
-		cmd:          cmd,
-		activeSince:  activeSince,
-		fragmentPath: properties["FragmentPath"],
-		err:          nil,
+	inspectServiceProcesses(
+		systemctlShowResult{
+			service:      userServiceName(uid, service),
+			cmd:          cmd,
+			activeSince:  activeSince,
+			fragmentPath: properties["FragmentPath"],
+			execPaths:    execPaths,
+			controlGroup: properties["ControlGroup"],
+			mainPid:      mainPid,
+			err:          nil,
+		},
+		ch,
+	)
+}
+
+// inspectServiceProcesses completes an active service's info with the one about its processes.
+func inspectServiceProcesses(result systemctlShowResult, ch chan<- systemctlShowResult) {
+	if result.activeSince != (time.Time{}) {
+		if result.mainPid > 0 {
+			// The service can still be judged by its start time.
+			// Other users' processes require root privileges (or CAP_SYS_PTRACE), that's just counted.
+			if exe, replaced, cTime, context, errPER := procExeReplaced(result.mainPid); errPER != nil {
+				if os.IsPermission(errPER) {
+					result.exeUninspectable = true
+				} else {
+					result.failures = map[string]error{context: errPER}
+				}
+			} else if replaced {
+				result.exeReplaced = exe
+				result.exeChanged = cTime
+			}
+		}
+
+		if allProcesses {
+			start, pid, context, errFOP := findOldestProcess(result.controlGroup)
+			if errFOP != nil {
+				ch <- systemctlShowResult{cmd: context, err: errFOP}
+				return
+			}
+
+			if pid > 0 {
+				result.activeSince = start
+				result.oldestPid = pid
+			}
+		}
 	}
+
+	ch <- result
+}
+
+// showServiceDeps queries the dependencies of a service relevant for restarting it.
+func showServiceDeps(uid, service string) (cmd string, deps serviceDeps, err error) {
+	cmd, rawProperties, errSSD := system(
+		"systemctl", append(
+			systemctlManagerArgs(uid),
+			"show",
+			"-p", "Requires", "-p", "BindsTo", "-p", "PartOf",
+			"-p", "RequiredBy", "-p", "BoundBy", "-p", "ConsistsOf",
+			"-p", "After",
+			service+".service",
+		),
+		map[string]string{"LC_ALL": "C"},
+		"/",
+	)
+	if errSSD != nil {
+		return cmd, serviceDeps{}, errSSD
+	}
+
+	properties := map[string][]string{}
+
+	for _, line := range bytes.Split(rawProperties, lineBreak) {
+		if match := serviceProperty.FindSubmatch(line); match != nil {
+			properties[string(match[1])] = strings.Fields(string(match[2]))
+		}
+	}
+
+	return cmd, newServiceDeps(uid, properties), nil
+}
+
+// monotonicToTime converts a CLOCK_MONOTONIC timestamp (in microseconds) to wall clock time.
+func monotonicToTime(usec uint64) (time.Time, error) {
+	var now unix.Timespec
+	if errCG := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); errCG != nil {
+		return time.Time{}, errCG
+	}
+
+	return time.Now().Add(time.Duration(usec)*time.Microsecond - time.Duration(now.Nano())), nil
+}
+
+// findOldestProcess finds the process of a control group started first.
+func findOldestProcess(controlGroup string) (start time.Time, pid uint64, context string, err error) {
+	pids, context, errCP := cgroupPids(controlGroup)
+	if errCP != nil {
+		return time.Time{}, 0, context, errCP
+	}
+
+	if len(pids) < 1 {
+		return
+	}
+
+	uptime, errGUT := linux.GetUptime()
+	if errGUT != nil {
+		return time.Time{}, 0, "cat /proc/uptime", errGUT
+	}
+
+	bootTime := time.Now().Add(-uptime.UpTime)
+
+	for _, p := range pids {
+		procStart, context, errPST := procStartTime(p, bootTime)
+		if errPST != nil {
+			return time.Time{}, 0, context, errPST
+		}
+
+		if procStart != (time.Time{}) && (pid == 0 || procStart.Before(start)) {
+			start = procStart
+			pid = p
+		}
+	}
+
+	return
 }
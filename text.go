@@ -0,0 +1,141 @@
+package main
+
+import (
+	pp "github.com/Al2Klimov/go-pretty-print"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// textRenderer aligns columns with spaces for terminals and non-HTML monitoring UIs.
+type textRenderer struct{}
+
+func (textRenderer) Render(result checkResult) string {
+	builder := &strings.Builder{}
+
+	if len(result.failures) > 0 {
+		builder.WriteString(failuresHeadline + "\n\n")
+
+		for _, failure := range sortedFailures(result.failures) {
+			builder.WriteString("  " + failure + "\n")
+		}
+
+		builder.WriteString("\n")
+	}
+
+	if result.exeUninspectable > 0 {
+		builder.WriteString(exeUninspectableNote(result.exeUninspectable) + "\n\n")
+	}
+
+	if len(result.restarts) > 0 {
+		assembleRestartsText(builder, result.restarts)
+	}
+
+	if len(result.binariesReplaced) > 0 {
+		builder.WriteString("Some services run binaries which have been replaced:\n\n")
+
+		table := newTextTable(builder, "")
+		table.row("SERVICE", "BINARY")
+
+		for _, service := range sortedServices(result.binariesReplaced) {
+			table.row(service, result.binariesReplaced[service])
+		}
+
+		table.Flush()
+		builder.WriteString("\n")
+	}
+
+	if len(result.services) > 0 {
+		assembleCriticalText(builder, result.services)
+	} else {
+		builder.WriteString(noStaleServices)
+	}
+
+	// The performance data follows directly.
+	return strings.TrimRight(builder.String(), "\n")
+}
+
+func assembleRestartsText(builder *strings.Builder, restarts []restartAttempt) {
+	builder.WriteString(restartsHeadline + "\n\n")
+
+	table := newTextTable(builder, "")
+	table.row("SERVICE", "RESULT")
+
+	for _, attempt := range restarts {
+		table.row(attempt.service, formatRestartAttempt(attempt))
+	}
+
+	table.Flush()
+	builder.WriteString("\n")
+}
+
+func assembleCriticalText(builder *strings.Builder, services []orderedService) {
+	builder.WriteString("Some services have not been restarted since some of their parts have been upgraded:\n\n")
+
+	table := newTextTable(builder, "")
+	table.row("SERVICE", "PACKAGES", "UPGRADE - SERVICE START")
+
+	for _, service := range services {
+		table.row(
+			service.name,
+			strconv.FormatInt(int64(len(service.packages)), 10),
+			pp.Duration(service.packages[0].files[0].diff).String(),
+		)
+	}
+
+	table.Flush()
+
+	if verbosity < verbosityPackages {
+		return
+	}
+
+	for _, service := range services {
+		builder.WriteString("\nService: " + service.name + "\n")
+
+		if service.pid > 0 {
+			builder.WriteString("  Oldest process: " + strconv.FormatUint(service.pid, 10) + "\n")
+		}
+
+		if service.severity != severityCritical {
+			builder.WriteString("  Severity: " + service.severity.String() + "\n")
+		}
+
+		if service.policy.rebootInstead {
+			builder.WriteString("  Reboot the host instead of restarting this service.\n")
+		}
+
+		if len(service.mappedVia) > 0 {
+			builder.WriteString("  Packages determined via: " + strings.Join(service.mappedVia, ", ") + "\n")
+		}
+
+		builder.WriteString("\n")
+
+		table := newTextTable(builder, "  ")
+		table.row("PACKAGE", "UPGRADE - SERVICE START")
+
+		for _, packag := range service.packages {
+			table.row(packag.name, pp.Duration(packag.files[0].diff).String())
+
+			if verbosity >= verbosityFiles {
+				for _, file := range staleFiles(packag) {
+					table.row("  "+file.path, pp.Duration(file.diff).String())
+				}
+			}
+		}
+
+		table.Flush()
+	}
+}
+
+type textTable struct {
+	*tabwriter.Writer
+	indent string
+}
+
+func newTextTable(builder *strings.Builder, indent string) textTable {
+	return textTable{tabwriter.NewWriter(builder, 0, 8, 2, ' ', 0), indent}
+}
+
+func (t textTable) row(cells ...string) {
+	t.Write([]byte(t.indent + strings.Join(cells, "\t") + "\n"))
+}